@@ -0,0 +1,297 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path 是解析后的JsonPath语法树, 可以用来检查路径中用到的key、是否包含filter等.
+// String返回规范的写法, ModeLegacy和ModeRFC9535都能解析成同样的语法树
+type Path struct {
+	Relative bool // 以@开头, 只出现在filter中
+	Segments []Segment
+}
+
+// Segment 对应.key、[...]以及..key、..[...]
+type Segment struct {
+	Descendant bool
+	Selectors  []Selector
+}
+
+// SelectorKind 是Selector的种类
+type SelectorKind int
+
+const (
+	NameSelector     SelectorKind = iota // .key、['key']
+	IndexSelector                        // [0]、[-1]
+	SliceSelector                        // [start:end:step]
+	WildcardSelector                     // .*、[*]
+	FilterSelector                       // [?(...)]
+)
+
+// Selector 是segment中的一个选择器, Kind决定哪个字段有效
+type Selector struct {
+	Kind   SelectorKind
+	Name   string
+	Index  int
+	Slice  Slice
+	Filter Expr
+}
+
+// Slice 是[start:end:step], 没有写start、end时HasStart、HasEnd为false, 没有写step时Step为1
+type Slice struct {
+	Start, End, Step int
+	HasStart, HasEnd bool
+}
+
+// Expr 是filter表达式的语法树节点, 可能是*Path、*LogicalExpr、*NotExpr、*ComparisonExpr、
+// *LiteralExpr、*RegexpExpr或者*FunctionExpr
+type Expr interface {
+	String() string
+	isExpr()
+}
+
+type (
+	// LogicalExpr 是用&&或者||连接的多个条件
+	LogicalExpr struct {
+		Op       string
+		Operands []Expr
+	}
+	// NotExpr 是!条件
+	NotExpr struct {
+		Operand Expr
+	}
+	// ComparisonExpr 是Left Op Right, Op可以是==、<等比较运算符, 也可以是ModeLegacy的in、=~等运算符
+	ComparisonExpr struct {
+		Op          string
+		Left, Right Expr
+	}
+	// LiteralExpr 是字符串、json.Number、true、false、null, 或者ModeLegacy中的数组
+	LiteralExpr struct {
+		Value interface{}
+	}
+	// RegexpExpr 是ModeLegacy中=~右边的/pattern/flags
+	RegexpExpr struct {
+		Pattern, Flags string
+	}
+	// FunctionExpr 是函数调用, 例如length(@.title)
+	FunctionExpr struct {
+		Name string
+		Args []Expr
+	}
+)
+
+func (*Path) isExpr()           {}
+func (*LogicalExpr) isExpr()    {}
+func (*NotExpr) isExpr()        {}
+func (*ComparisonExpr) isExpr() {}
+func (*LiteralExpr) isExpr()    {}
+func (*RegexpExpr) isExpr()     {}
+func (*FunctionExpr) isExpr()   {}
+
+// Parse 只解析jsonPath不求值, 可以在加载配置时检查路径是否合法
+func Parse(jsonPath string) (*Path, error) {
+	return ParseWithOptions(jsonPath, Options{})
+}
+
+// ParseWithOptions 和Parse一样, 但是按opts.Mode对应的语法解析
+func ParseWithOptions(jsonPath string, opts Options) (*Path, error) {
+	q, err := parseQuery(jsonPath, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+	return newPath(q), nil
+}
+
+func newPath(q *query) *Path {
+	p := &Path{Relative: q.relative, Segments: make([]Segment, 0, len(q.segments))}
+	for _, seg := range q.segments {
+		s := Segment{Descendant: seg.descendant, Selectors: make([]Selector, 0, len(seg.selectors))}
+		for _, sel := range seg.selectors {
+			s.Selectors = append(s.Selectors, newSelector(sel))
+		}
+		p.Segments = append(p.Segments, s)
+	}
+	return p
+}
+
+func newSelector(sel selector) Selector {
+	switch sel.op {
+	case keyType:
+		return Selector{Kind: NameSelector, Name: sel.key}
+	case idxType:
+		return Selector{Kind: IndexSelector, Index: sel.index}
+	case rangeType:
+		a := sel.slice
+		return Selector{Kind: SliceSelector, Slice: Slice{Start: a.start, End: a.end, Step: a.step, HasStart: a.hasStart, HasEnd: a.hasEnd}}
+	case filterType:
+		return Selector{Kind: FilterSelector, Filter: newExpr(sel.filter)}
+	default:
+		return Selector{Kind: WildcardSelector}
+	}
+}
+
+func newExpr(e expr) Expr {
+	switch e := e.(type) {
+	case orExpr:
+		return &LogicalExpr{Op: "||", Operands: newExprs(e.operands)}
+	case andExpr:
+		return &LogicalExpr{Op: "&&", Operands: newExprs(e.operands)}
+	case notExpr:
+		return &NotExpr{Operand: newExpr(e.operand)}
+	case cmpExpr:
+		return &ComparisonExpr{Op: e.op, Left: newExpr(e.left), Right: newExpr(e.right)}
+	case queryExpr:
+		return newPath(e.q)
+	case legacyValue:
+		return newPath(e.q)
+	case literalExpr:
+		return &LiteralExpr{Value: e.value}
+	case funcExpr:
+		return &FunctionExpr{Name: e.name, Args: newExprs(e.args)}
+	case legacyFilter:
+		if e.op == "exists" {
+			return newExpr(e.left)
+		}
+		c := &ComparisonExpr{Op: e.op, Left: newExpr(e.left), Right: newExpr(e.right)}
+		if e.op == "=~" {
+			rp := e.right.(literalExpr).value.(string)
+			i := strings.LastIndexByte(rp, '/')
+			c.Right = &RegexpExpr{Pattern: rp[1:i], Flags: rp[i+1:]}
+		}
+		return c
+	}
+	return nil
+}
+
+func newExprs(es []expr) []Expr {
+	res := make([]Expr, 0, len(es))
+	for _, e := range es {
+		res = append(res, newExpr(e))
+	}
+	return res
+}
+
+// String 返回规范的写法: 能用.key表示的key用.key, 其他的key用单引号的['key'], filter统一写成?(...)
+func (p *Path) String() string {
+	var b strings.Builder
+	if p.Relative {
+		b.WriteString("@")
+	} else {
+		b.WriteString("$")
+	}
+	for _, seg := range p.Segments {
+		b.WriteString(seg.String())
+	}
+	return b.String()
+}
+
+func (s Segment) String() string {
+	prefix := ""
+	if s.Descendant {
+		prefix = "."
+	}
+	if len(s.Selectors) == 1 {
+		switch sel := s.Selectors[0]; {
+		case sel.Kind == NameSelector && isShorthandName(sel.Name):
+			return prefix + "." + sel.Name
+		case sel.Kind == WildcardSelector:
+			return prefix + ".*"
+		}
+	}
+	if s.Descendant {
+		prefix = ".."
+	}
+	parts := make([]string, 0, len(s.Selectors))
+	for _, sel := range s.Selectors {
+		parts = append(parts, sel.String())
+	}
+	return prefix + "[" + strings.Join(parts, ",") + "]"
+}
+
+// String 返回方括号中的写法
+func (s Selector) String() string {
+	switch s.Kind {
+	case NameSelector:
+		return "'" + escapeName(s.Name) + "'"
+	case IndexSelector:
+		return strconv.Itoa(s.Index)
+	case SliceSelector:
+		a := s.Slice
+		return sliceArgs{start: a.Start, end: a.End, step: a.Step, hasStart: a.HasStart, hasEnd: a.HasEnd}.String()
+	case FilterSelector:
+		return "?(" + s.Filter.String() + ")"
+	default:
+		return "*"
+	}
+}
+
+// isShorthandName 判断key是否符合RFC 9535的member-name-shorthand
+func isShorthandName(name string) bool {
+	for i, r := range name {
+		if !isNameFirst(r) && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (e *LogicalExpr) String() string {
+	parts := make([]string, 0, len(e.Operands))
+	for _, operand := range e.Operands {
+		if l, ok := operand.(*LogicalExpr); ok && l.Op == "||" {
+			parts = append(parts, "("+operand.String()+")")
+		} else {
+			parts = append(parts, operand.String())
+		}
+	}
+	return strings.Join(parts, " "+e.Op+" ")
+}
+
+func (e *NotExpr) String() string {
+	switch e.Operand.(type) {
+	case *LogicalExpr, *ComparisonExpr:
+		return "!(" + e.Operand.String() + ")"
+	}
+	return "!" + e.Operand.String()
+}
+
+func (e *ComparisonExpr) String() string {
+	return e.Left.String() + " " + e.Op + " " + e.Right.String()
+}
+
+func (e *LiteralExpr) String() string {
+	return formatLiteral(e.Value)
+}
+
+func formatLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + escapeName(v) + "'"
+	case json.Number:
+		return string(v)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, each := range v {
+			parts = append(parts, formatLiteral(each))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func (e *RegexpExpr) String() string {
+	return "/" + e.Pattern + "/" + e.Flags
+}
+
+func (e *FunctionExpr) String() string {
+	args := make([]string, 0, len(e.Args))
+	for _, arg := range e.Args {
+		args = append(args, arg.String())
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
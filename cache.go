@@ -0,0 +1,140 @@
+package jsonpath
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize 是编译缓存默认的容量
+const DefaultCacheSize = 512
+
+// CacheStats 是编译缓存的统计信息
+type CacheStats struct {
+	Hits      uint64 // 命中缓存的次数
+	Misses    uint64 // 没有命中, 重新解析的次数
+	Evictions uint64 // 因为超出容量被淘汰的次数
+	Size      int    // 当前缓存的路径个数
+	Capacity  int    // 容量, 0表示关闭缓存
+}
+
+// cacheKey 同一个路径在不同的Options下解析结果不同
+type cacheKey struct {
+	path string
+	opts Options
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	compiled *Compiled
+}
+
+// lruCache 是Lookup等函数使用的编译缓存, 按最近使用的顺序淘汰
+type lruCache struct {
+	mu    sync.Mutex
+	items map[cacheKey]*list.Element
+	order *list.List // 最近使用的在前面
+	stats CacheStats
+}
+
+var compiledCache = newLRUCache(DefaultCacheSize)
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		items: make(map[cacheKey]*list.Element),
+		order: list.New(),
+		stats: CacheStats{Capacity: capacity},
+	}
+}
+
+// SetCacheSize 设置Lookup、Query、DeleteByKey、Rename等函数使用的编译缓存的容量, n <= 0时关闭缓存.
+// 容量变小时淘汰最久没有使用的路径
+func SetCacheSize(n int) {
+	compiledCache.resize(n)
+}
+
+// GetCacheStats 返回编译缓存的统计信息
+func GetCacheStats() CacheStats {
+	return compiledCache.snapshot()
+}
+
+// ResetCache 清空编译缓存和统计信息, 容量不变
+func ResetCache() {
+	compiledCache.reset()
+}
+
+// compileCached 和CompileWithOptions一样, 但是优先使用缓存. 解析失败的路径不会被缓存
+func compileCached(jsonPath string, opts Options) (*Compiled, error) {
+	key := cacheKey{path: jsonPath, opts: opts}
+	if c, ok := compiledCache.get(key); ok {
+		return c, nil
+	}
+	c, err := CompileWithOptions(jsonPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	compiledCache.add(key, c)
+	return c, nil
+}
+
+func (l *lruCache) get(key cacheKey) (*Compiled, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[key]; ok {
+		l.order.MoveToFront(el)
+		l.stats.Hits++
+		return el.Value.(*cacheEntry).compiled, true
+	}
+	l.stats.Misses++
+	return nil, false
+}
+
+func (l *lruCache) add(key cacheKey, c *Compiled) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stats.Capacity <= 0 {
+		return
+	}
+	if el, ok := l.items[key]; ok {
+		// 其他goroutine同时解析了同一个路径
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(&cacheEntry{key: key, compiled: c})
+	l.evict()
+}
+
+func (l *lruCache) resize(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	l.stats.Capacity = n
+	l.evict()
+}
+
+// evict 淘汰超出容量的路径, 调用时需要持有锁
+func (l *lruCache) evict() {
+	for l.order.Len() > l.stats.Capacity {
+		el := l.order.Back()
+		l.order.Remove(el)
+		delete(l.items, el.Value.(*cacheEntry).key)
+		l.stats.Evictions++
+	}
+}
+
+func (l *lruCache) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = make(map[cacheKey]*list.Element)
+	l.order.Init()
+	l.stats = CacheStats{Capacity: l.stats.Capacity}
+}
+
+func (l *lruCache) snapshot() CacheStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.stats
+	stats.Size = l.order.Len()
+	return stats
+}
@@ -0,0 +1,143 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ctsCase 对应JSONPath Compliance Test Suite中的一条用例
+type ctsCase struct {
+	Name            string          `json:"name"`
+	Selector        string          `json:"selector"`
+	Document        interface{}     `json:"document"`
+	Result          []interface{}   `json:"result"`
+	Results         [][]interface{} `json:"results"`
+	ResultPaths     []string        `json:"result_paths"`
+	ResultsPaths    [][]string      `json:"results_paths"`
+	InvalidSelector bool            `json:"invalid_selector"`
+}
+
+// ctsURL 是上游测试集的地址, 更新testdata/cts.json时使用这个仓库中固定commit的cts.json, 不要修改文件内容
+const ctsURL = "https://github.com/jsonpath-standard/jsonpath-compliance-test-suite"
+
+// ctsSkips 是上游测试集中跳过的用例, key是用例的name, value是跳过的原因
+var ctsSkips = map[string]string{}
+
+func loadCTS(t *testing.T, file string) []ctsCase {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite struct {
+		Tests []ctsCase `json:"tests"`
+	}
+	if err := json.Unmarshal(raw, &suite); err != nil {
+		t.Fatal(err)
+	}
+	return suite.Tests
+}
+
+// TestCompliance 运行上游的JSONPath Compliance Test Suite, 见ctsURL
+func TestCompliance(t *testing.T) {
+	if _, err := os.Stat("testdata/cts.json"); os.IsNotExist(err) {
+		t.Skipf("testdata/cts.json not found, download it from %s", ctsURL)
+	}
+	for _, tcase := range loadCTS(t, "testdata/cts.json") {
+		tcase := tcase
+		t.Run(tcase.Name, func(t *testing.T) {
+			if reason, ok := ctsSkips[tcase.Name]; ok {
+				t.Skip(reason)
+			}
+			runCTSCase(t, tcase)
+		})
+	}
+}
+
+// TestRFC9535Cases 运行testdata/rfc9535.json, 这些用例是本仓库自己整理的, 只是使用了和上游测试集相同的格式
+func TestRFC9535Cases(t *testing.T) {
+	for _, tcase := range loadCTS(t, "testdata/rfc9535.json") {
+		tcase := tcase
+		t.Run(tcase.Name, func(t *testing.T) {
+			runCTSCase(t, tcase)
+		})
+	}
+}
+
+func runCTSCase(t *testing.T, tcase ctsCase) {
+	c, err := CompileWithOptions(tcase.Selector, Options{Mode: ModeRFC9535})
+	if tcase.InvalidSelector {
+		assert.NotNil(t, err, "selector %q should be invalid", tcase.Selector)
+		return
+	}
+	if !assert.Nil(t, err, "selector %q", tcase.Selector) {
+		return
+	}
+	nodes, err := c.selectNodes(tcase.Document)
+	assert.Nil(t, err)
+	values := make([]interface{}, 0, len(nodes))
+	paths := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		values = append(values, n.value)
+		paths = append(paths, n.loc.normalized())
+	}
+	if tcase.Results != nil {
+		// 结果的顺序不确定时, 值和路径需要匹配同一个候选
+		matched := false
+		for i, result := range tcase.Results {
+			ok := reflect.DeepEqual(result, values)
+			if ok && i < len(tcase.ResultsPaths) {
+				ok = reflect.DeepEqual(tcase.ResultsPaths[i], paths)
+			}
+			matched = matched || ok
+		}
+		assert.True(t, matched, "selector %q got %v at %v", tcase.Selector, values, paths)
+		return
+	}
+	assert.Equal(t, tcase.Result, values, "selector %q", tcase.Selector)
+	if tcase.ResultPaths != nil {
+		assert.Equal(t, tcase.ResultPaths, paths, "selector %q", tcase.Selector)
+	}
+}
+
+func TestLookupWithOptions(t *testing.T) {
+	res, err := LookupWithOptions(jsonData, "$.store.book[?@.price < 10 && @.category == 'fiction'].title", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$['store']['book'][2]['title']": "Moby Dick",
+	}, res)
+
+	res, err = LookupWithOptions(jsonData, "$..book[-1:].author", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$['store']['book'][3]['author']": "J. R. R. Tolkien",
+	}, res)
+
+	_, err = LookupWithOptions(jsonData, "$.store.book[?(@.price < $.expensive)]", Options{})
+	assert.Nil(t, err)
+	_, err = LookupWithOptions(jsonData, "$.store.book[?@.author == executor]", Options{Mode: ModeRFC9535})
+	assert.NotNil(t, err)
+
+	// RFC 9535中没有数组literal和不带引号的字符串
+	for _, path := range []string{"$[?@.a==[1]]", "$[?@.a==[foo]]", "$[?@ == [1]]", "$[?@.x == ['a']]", "$[?[1] == @.a]"} {
+		_, err = CompileWithOptions(path, Options{Mode: ModeRFC9535})
+		assert.ErrorIs(t, err, ErrSyntax, path)
+	}
+	_, err = Compile("$[?(@.a in [1, 2])]")
+	assert.Nil(t, err)
+}
+
+func TestRegexpCache(t *testing.T) {
+	data := []interface{}{"abcdef", "abc"}
+	opts := Options{Mode: ModeRFC9535}
+	// search的'^abc'和match的'abc'不能共用缓存的正则
+	res, err := LookupWithOptions(data, "$[?search(@, '^abc')]", opts)
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	res, err = LookupWithOptions(data, "$[?match(@, 'abc')]", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$[1]": "abc"}, res)
+}
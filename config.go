@@ -53,13 +53,20 @@ func (r renameConfigParse) renameFrom(index int) error {
 	return nil
 }
 
-func (r RenamesConfig) parseConfig() ([]renameConfigParse, int) {
+func (r RenamesConfig) parseConfig() ([]renameConfigParse, int, error) {
 	result := make([]renameConfigParse, 0, len(r.Config))
 	maxLen := -1
 	for _, each := range r.Config {
-		parse := renameConfigParse{}
-		parse.FromParse = strings.Split(each.From, ".")
-		parse.ToParse = strings.Split(each.To, ".")
+		var (
+			parse renameConfigParse
+			err   error
+		)
+		if parse.FromParse, err = splitLevels(each.From); err != nil {
+			return nil, 0, err
+		}
+		if parse.ToParse, err = splitLevels(each.To); err != nil {
+			return nil, 0, err
+		}
 		parse.Len = len(parse.FromParse) - 1
 		if parse.Len > maxLen {
 			maxLen = parse.Len
@@ -67,5 +74,71 @@ func (r RenamesConfig) parseConfig() ([]renameConfigParse, int) {
 		result = append(result, parse)
 	}
 
-	return result, maxLen
+	return result, maxLen, nil
+}
+
+// splitLevels 按key把路径拆成多层, 第一层是$. 例如$.store.book[*]['a.b'] => $, store, book[*], ['a.b']
+// 每一层用.拼接起来仍然是合法的路径
+func splitLevels(path string) ([]string, error) {
+	q, err := parseQuery(path, ModeLegacy)
+	if err != nil {
+		return nil, err
+	}
+	levels := []string{"$"}
+	for _, seg := range q.segments {
+		if seg.isKey() {
+			levels = append(levels, strings.TrimPrefix(formatSegment(seg), "."))
+			continue
+		}
+		levels[len(levels)-1] += formatSegment(seg)
+	}
+	return levels, nil
+}
+
+// Mode 选择JsonPath的语法和求值规则
+type Mode int
+
+const (
+	// ModeLegacy 原有的Goessner风格语法, 是Lookup的默认行为
+	ModeLegacy Mode = iota
+	// ModeRFC9535 严格按照RFC 9535解析和求值, 结果的key为Normalized Path
+	ModeRFC9535
+)
+
+// Policy 决定路径中缺少的key、越界的下标和类型不符的值怎么处理.
+// 查找时只对不在..、多选择器和filter中的key、下标生效, 例如$.a[0]中的a和0
+type Policy int
+
+const (
+	// PolicyDefault 各个函数原有的行为: ModeLegacy查找时下标越界、对非数组取下标返回错误, 缺少的key跳过;
+	// SetToBody下标越界返回错误, 缺少中间的key时不修改; DeleteBody、DeleteByKey跳过不存在的路径
+	PolicyDefault Policy = iota
+	// PolicyStrict 查找和修改时, 缺少的key、越界的下标、类型不符的值都返回错误.
+	// SetToBody只有最后一个key可以不存在, 这时会添加这个key
+	PolicyStrict
+	// PolicyLenient 查找和修改时都跳过选不中的路径, 不返回错误
+	PolicyLenient
+	// PolicyRFC9535 查找时和RFC 9535一样选不中时返回空的结果, 修改时和PolicyStrict一样返回错误
+	PolicyRFC9535
+)
+
+// Options 控制一次查找的行为, 零值等价于Lookup
+type Options struct {
+	Mode Mode `json:"mode"`
+	// Policy 决定选不中时是否返回错误, 对查找和SetToBody、DeleteBody、DeleteByKey、Rename都生效
+	Policy Policy `json:"policy"`
+	// InclusiveSliceEnd 兼容旧版本的切片, [from:to]包含to, 只在ModeLegacy下生效
+	InclusiveSliceEnd bool `json:"inclusive_slice_end"`
+	// NormalizedPaths ModeLegacy下结果的key也使用Normalized Path, 例如$['store']['book'][0]
+	NormalizedPaths bool `json:"normalized_paths"`
+	// Limits 限制一次查找使用的资源, 零值表示不限制
+	Limits Limits `json:"limits"`
+}
+
+// Limits 限制执行不可信的jsonPath时使用的资源, 超出时返回*LimitError. 字段为0表示不限制
+type Limits struct {
+	MaxResults     int `json:"max_results"`      // 最多选中的值, 包括ModeLegacy中被去重的值
+	MaxNodes       int `json:"max_nodes"`        // 最多访问的节点, 包括filter中子路径访问的节点
+	MaxDepth       int `json:"max_depth"`        // 最多访问到文档的第几层, $的子节点是第1层
+	MaxQueryLength int `json:"max_query_length"` // jsonPath的最大字节数
 }
@@ -0,0 +1,148 @@
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// 可以用errors.Is判断错误的种类, 用errors.As取得具体的错误类型
+var (
+	// ErrSyntax jsonPath不符合语法, 具体的错误是*SyntaxError
+	ErrSyntax = errors.New("syntax error")
+	// ErrIndexOutOfRange 下标超出数组范围, 具体的错误是*IndexError
+	ErrIndexOutOfRange = errors.New("index out of range")
+	// ErrTypeMismatch 值的类型不符合要求, 具体的错误是*TypeError
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrNotFound 路径没有选中任何值, 具体的错误是*NotFoundError
+	ErrNotFound = errors.New("not found")
+	// ErrMultipleMatches 需要唯一一个值的地方选中了多个值, 具体的错误是*MultipleMatchesError
+	ErrMultipleMatches = errors.New("multiple matches")
+	// ErrInvalidPath 需要固定路径的地方传入了通配、切片、filter等路径
+	ErrInvalidPath = errors.New("invalid Key full path")
+	// ErrLimitExceeded 超出了Options.Limits中的限制, 具体的错误是*LimitError
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrNotStreamable 路径不能用Stream、Iterate按流的方式求值
+	ErrNotStreamable = errors.New("query cannot be streamed")
+)
+
+// SyntaxError 是解析jsonPath时的错误
+type SyntaxError struct {
+	Query  string // 出错的jsonPath
+	Offset int    // 出错位置在Query中的字节偏移
+	Token  string // 出错位置的字符, 到达末尾时为空
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("syntax error in %q at %d: %s", e.Query, e.Offset, e.Msg)
+	}
+	return fmt.Sprintf("syntax error in %q at %d near %q: %s", e.Query, e.Offset, e.Token, e.Msg)
+}
+
+func (e *SyntaxError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+// IndexError 是下标超出数组范围的错误
+type IndexError struct {
+	Path   string // 数组的Normalized Path
+	Index  int    // 路径中写的下标, 可能是负数
+	Length int    // 数组的长度
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index out of range: len: %v, idx: %v, path: %s", e.Length, e.Index, e.Path)
+}
+
+func (e *IndexError) Is(target error) bool {
+	return target == ErrIndexOutOfRange
+}
+
+// TypeError 是值的类型不符合要求的错误, 例如对object取下标, 或者LookupAs不能转换的值
+type TypeError struct {
+	Path     string // 值的路径
+	Expected string // 需要的类型, 例如array、string、int64
+	Actual   string // 实际的类型, 例如object、number
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%s is %s, not %s", e.Path, e.Actual, e.Expected)
+}
+
+func (e *TypeError) Is(target error) bool {
+	return target == ErrTypeMismatch
+}
+
+// NotFoundError 是路径没有选中值的错误
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.Path)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// MultipleMatchesError 是GetString等函数的路径选中了多个值的错误
+type MultipleMatchesError struct {
+	Path  string
+	Count int // 选中的值的个数
+}
+
+func (e *MultipleMatchesError) Error() string {
+	return fmt.Sprintf("%s should select exactly one value, got %d", e.Path, e.Count)
+}
+
+func (e *MultipleMatchesError) Is(target error) bool {
+	return target == ErrMultipleMatches
+}
+
+// LimitError 是超出Options.Limits的错误
+type LimitError struct {
+	Limit string // 超出的限制: results、nodes、depth、query length
+	Max   int    // 设置的上限
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit exceeded: %s > %d", e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// typeName 返回值在json中的类型, 用于错误信息
+func typeName(v interface{}) string {
+	v = scalar(v)
+	if v == nil {
+		return "null"
+	}
+	if v == nothing {
+		return "nothing"
+	}
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+	if r, ok := v.(*rawNode); ok {
+		if r.object {
+			return "object"
+		}
+		return "array"
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,996 @@
+package jsonpath
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// node 是求值过程中选中的一个值以及它在文档中的位置
+type node struct {
+	value  interface{}
+	parent interface{}
+	loc    *location
+}
+
+// location 以链表的形式记录从$到当前节点的路径, nil表示$
+type location struct {
+	parent  *location
+	key     string
+	index   int
+	written int // 路径中写的下标, 可能是负数, 只用于legacy()
+	isIndex bool
+	depth   int // 在文档中的层数, $的子节点是1
+}
+
+func (l *location) member(key string) *location {
+	return &location{parent: l, key: key, depth: l.level() + 1}
+}
+
+func (l *location) element(index int) *location {
+	return &location{parent: l, index: index, written: index, isIndex: true, depth: l.level() + 1}
+}
+
+func (l *location) level() int {
+	if l == nil {
+		return 0
+	}
+	return l.depth
+}
+
+// elements 返回从$开始的路径元素
+func (l *location) elements() []*location {
+	var res []*location
+	for ; l != nil; l = l.parent {
+		res = append(res, l)
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+// normalized 返回RFC 9535定义的Normalized Path, 例如 $['store']['book'][0]
+func (l *location) normalized() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, e := range l.elements() {
+		if e.isIndex {
+			b.WriteString("[" + strconv.Itoa(e.index) + "]")
+		} else {
+			b.WriteString("['" + escapeName(e.key) + "']")
+		}
+	}
+	return b.String()
+}
+
+// legacy 返回ModeLegacy风格的路径, 例如 $.store.book[0], 无法用.表示的key使用['a.b']
+func (l *location) legacy() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, e := range l.elements() {
+		if e.isIndex {
+			b.WriteString("[" + strconv.Itoa(e.written) + "]")
+		} else {
+			b.WriteString(formatName(e.key))
+		}
+	}
+	return b.String()
+}
+
+// formatName 把key格式化成.key, 包含特殊字符的key使用['key']
+func formatName(name string) string {
+	if name == "" || strings.HasPrefix(name, "*") || strings.ContainsAny(name, ".[]'\"\\") || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "['" + escapeName(name) + "']"
+	}
+	return "." + name
+}
+
+func escapeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch r {
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if r < 0x20 {
+				b.WriteString(fmt.Sprintf(`\u%04x`, r))
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// evaluator 保存一次求值过程中的状态, 每次Lookup都会新建一个
+type evaluator struct {
+	root      interface{}
+	current   *location // filter正在检查的节点, filter中@开头的子路径从这里开始
+	legacy    bool      // ModeLegacy: 保留路径中的负数下标, [:]作用在object上等价于[*]
+	strict    bool      // 单个下标越界、对非数组取下标时返回错误
+	missing   bool      // 缺少的key、对null取值时也返回错误, 只有PolicyStrict为true
+	inclusive bool      // 切片包含end, 见Options.InclusiveSliceEnd
+	ctx       context.Context
+	limits    Limits
+	visited   int // 已经访问的节点数
+	results   int // 已经选中的节点数
+	err       error
+}
+
+// checkInterval 每访问这么多节点检查一次ctx是否已经取消
+const checkInterval = 1024
+
+// visit 记录访问了一个节点, 超出限制或者ctx已经取消时设置e.err并返回false
+func (e *evaluator) visit(n node) bool {
+	e.visited++
+	if max := e.limits.MaxNodes; max > 0 && e.visited > max {
+		e.err = &LimitError{Limit: "nodes", Max: max}
+		return false
+	}
+	if max := e.limits.MaxDepth; max > 0 && n.loc.level() > max {
+		e.err = &LimitError{Limit: "depth", Max: max}
+		return false
+	}
+	if e.ctx != nil && e.visited%checkInterval == 0 {
+		if err := e.ctx.Err(); err != nil {
+			e.err = err
+			return false
+		}
+	}
+	return true
+}
+
+// run 对query求值, 每选中一个节点就调用emit, emit返回false时停止求值
+func (e *evaluator) run(q *query, current interface{}, emit func(node) bool) bool {
+	start := node{value: e.root}
+	if q.relative {
+		start.value, start.loc = current, e.current
+	}
+	return e.segments(start, q.segments, emit)
+}
+
+func (e *evaluator) segments(n node, segs []segment, emit func(node) bool) bool {
+	if e.err != nil || !e.visit(n) {
+		return false
+	}
+	if len(segs) == 0 {
+		return emit(n)
+	}
+	next := func(child node) bool {
+		return e.segments(child, segs[1:], emit)
+	}
+	if segs[0].descendant {
+		return e.descend(n, segs[0].selectors, next)
+	}
+	return e.selectors(n, segs[0].selectors, true, next)
+}
+
+// descend 先对当前节点求值, 再按文档顺序递归所有子孙节点, 子孙节点不是数组或者下标越界时直接跳过
+func (e *evaluator) descend(n node, sels []selector, emit func(node) bool) bool {
+	if !e.selectors(n, sels, false, emit) {
+		return false
+	}
+	return children(n, func(child node) bool {
+		return e.visit(child) && e.descend(child, sels, emit)
+	})
+}
+
+// selectors 按顺序对每个selector求值, 多个selector选中同一个节点时会重复emit.
+// single为true表示selector不在..中, 这时按e.strict和e.missing决定选不中时是否返回错误
+func (e *evaluator) selectors(n node, sels []selector, single bool, emit func(node) bool) bool {
+	for i := range sels {
+		if !e.selector(n, &sels[i], single, len(sels) > 1, emit) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *evaluator) selector(n node, s *selector, single, union bool, emit func(node) bool) bool {
+	// 多个selector时其中一个选不中不算错误, 例如$.a[0,'b']
+	single = single && !union
+	strict := single && e.strict
+	switch s.op {
+	case keyType:
+		if v, ok := member(n.value, s.key); ok {
+			return emit(node{value: v, parent: n.value, loc: n.loc.member(s.key)})
+		}
+		if strict && e.missing {
+			if _, ok := objectLen(n.value); ok {
+				e.err = &NotFoundError{Path: n.loc.member(s.key).normalized()}
+			} else {
+				e.err = &TypeError{Path: n.loc.normalized(), Expected: "object", Actual: typeName(n.value)}
+			}
+			return false
+		}
+	case idxType:
+		length, ok := arrayLen(n.value)
+		if !ok {
+			if strict && (n.value != nil || e.missing) {
+				e.err = &TypeError{Path: n.loc.normalized(), Expected: "array", Actual: typeName(n.value)}
+				return false
+			}
+			return true
+		}
+		idx := s.index
+		if idx < 0 {
+			idx += length
+		}
+		if idx < 0 || idx >= length {
+			if strict {
+				e.err = &IndexError{Path: n.loc.normalized(), Index: s.index, Length: length}
+				return false
+			}
+			return true
+		}
+		loc := n.loc.element(idx)
+		if e.legacy && !union {
+			// 原有的key保留路径中的负数下标, 例如$.store.book[-1]; 多个selector时统一用实际下标, 方便去重
+			loc.written = s.index
+		}
+		return emit(node{value: element(n.value, idx), parent: n.value, loc: loc})
+	case rangeType:
+		length, ok := arrayLen(n.value)
+		if !ok {
+			// 原有语法中[:]作用在object上等价于[*]
+			if _, ok := objectLen(n.value); ok && single && e.legacy && !s.slice.hasStart && !s.slice.hasEnd {
+				return children(n, emit)
+			}
+			if !strict || (n.value == nil && !e.missing) {
+				return true
+			}
+			e.err = &TypeError{Path: n.loc.normalized(), Expected: "array", Actual: typeName(n.value)}
+			return false
+		}
+		args := s.slice
+		if e.inclusive {
+			args = args.inclusive()
+		}
+		for _, idx := range sliceIndexes(args, length) {
+			if !emit(node{value: element(n.value, idx), parent: n.value, loc: n.loc.element(idx)}) {
+				return false
+			}
+		}
+	case scanType:
+		return children(n, emit)
+	case filterType:
+		return children(n, func(child node) bool {
+			outer := e.current
+			e.current = child.loc
+			ok := e.test(s.filter, child.value)
+			e.current = outer
+			if ok {
+				return emit(child)
+			}
+			return e.err == nil
+		})
+	}
+	return true
+}
+
+// sliceIndexes 按RFC 9535的规则计算切片选中的下标
+func sliceIndexes(s sliceArgs, length int) []int {
+	if s.step == 0 {
+		return nil
+	}
+	normalize := func(i int) int {
+		if i < 0 {
+			return length + i
+		}
+		return i
+	}
+	var res []int
+	if s.step > 0 {
+		start, end := 0, length
+		if s.hasStart {
+			start = normalize(s.start)
+		}
+		if s.hasEnd {
+			end = normalize(s.end)
+		}
+		lower, upper := clamp(start, 0, length), clamp(end, 0, length)
+		for i := lower; i < upper; i += s.step {
+			res = append(res, i)
+		}
+		return res
+	}
+	start, end := length-1, -length-1
+	if s.hasStart {
+		start = normalize(s.start)
+	}
+	if s.hasEnd {
+		end = normalize(s.end)
+	}
+	upper, lower := clamp(start, -1, length-1), clamp(end, -1, length-1)
+	for i := upper; i > lower; i += s.step {
+		res = append(res, i)
+	}
+	return res
+}
+
+// inclusive 把包含end的切片转换成不包含end的写法, step为负数时end在另一侧
+func (s sliceArgs) inclusive() sliceArgs {
+	switch {
+	case !s.hasEnd:
+	case s.step > 0 && s.end == -1, s.step < 0 && s.end == 0:
+		s.hasEnd = false
+	case s.step > 0:
+		s.end++
+	case s.step < 0:
+		s.end--
+	}
+	return s
+}
+
+func clamp(i, lower, upper int) int {
+	if i < lower {
+		return lower
+	}
+	if i > upper {
+		return upper
+	}
+	return i
+}
+
+// test 对filter表达式求值, 结果为LogicalType
+func (e *evaluator) test(x expr, current interface{}) bool {
+	switch x := x.(type) {
+	case orExpr:
+		for _, operand := range x.operands {
+			if e.test(operand, current) {
+				return true
+			}
+		}
+		return false
+	case andExpr:
+		for _, operand := range x.operands {
+			if !e.test(operand, current) {
+				return false
+			}
+		}
+		return true
+	case notExpr:
+		return !e.test(x.operand, current)
+	case cmpExpr:
+		return compareValues(e.value(x.left, current), e.value(x.right, current), x.op)
+	case queryExpr:
+		found := false
+		e.subquery(x.q, current, func(node) bool {
+			found = true
+			return false
+		})
+		return found
+	case legacyFilter:
+		ok, err := e.legacyCmp(x, current)
+		if err != nil {
+			e.err = err
+		}
+		return ok
+	case funcExpr:
+		switch res := e.call(x, current).(type) {
+		case bool:
+			return res
+		case []node:
+			return len(res) > 0
+		}
+	}
+	return false
+}
+
+// legacyCmp 按原有规则对lp op rp求值. 路径选中多个值时, 只要有一个值满足条件就返回true;
+// 但是作为in、nin右边和subsetof、anyof、noneof两边的数组时, 选中的值合并成一个数组
+func (e *evaluator) legacyCmp(f legacyFilter, current interface{}) (bool, error) {
+	if f.op == "exists" {
+		if _, ok := f.left.(funcExpr); ok {
+			return e.test(f.left, current), nil
+		}
+		for _, v := range e.legacyValues(f.left, current) {
+			if v != nil && v != nothing {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var lefts []interface{}
+	switch f.op {
+	case "subsetof", "anyof", "noneof":
+		lefts = e.legacyList(f.left, current)
+	default:
+		lefts = e.legacyValues(f.left, current)
+	}
+	if f.op == "=~" {
+		for _, left := range lefts {
+			s, ok := left.(string)
+			if !ok {
+				return false, &TypeError{Path: e.operandPath(f.left), Expected: "string", Actual: typeName(left)}
+			}
+			if f.pat.MatchString(s) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var rights []interface{}
+	switch f.op {
+	case "in", "nin", "subsetof", "anyof", "noneof":
+		rights = e.legacyList(f.right, current)
+	default:
+		rights = e.legacyValues(f.right, current)
+	}
+	for _, left := range lefts {
+		for _, right := range rights {
+			ok, err := cmpAny(left, right, f.op)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// operandPath 返回filter中一边的路径, 用于错误信息. @开头的子路径接在正在检查的节点后面
+func (e *evaluator) operandPath(x expr) string {
+	v, ok := x.(legacyValue)
+	if !ok {
+		return e.current.normalized()
+	}
+	p := newPath(v.q).String()
+	if v.q.relative {
+		return e.current.normalized() + p[1:]
+	}
+	return p
+}
+
+// legacyList 和legacyValues一样, 但是选中多个值的路径会把这些值合并成一个数组
+func (e *evaluator) legacyList(x expr, current interface{}) []interface{} {
+	if v, ok := x.(legacyValue); ok && !v.q.singular() {
+		return []interface{}{e.legacyValues(x, current)}
+	}
+	return e.legacyValues(x, current)
+}
+
+// legacyValues 返回比较一边的所有值, 单个值的路径没有选中时为nothing, 只和nothing相等
+func (e *evaluator) legacyValues(x expr, current interface{}) []interface{} {
+	if v, ok := x.(legacyValue); ok {
+		var res []interface{}
+		e.subquery(v.q, current, func(n node) bool {
+			res = append(res, scalar(n.value))
+			return true
+		})
+		if len(res) == 0 && v.q.singular() {
+			res = append(res, nothing)
+		}
+		return res
+	}
+	if res := e.value(x, current); res != nothing {
+		return []interface{}{res}
+	}
+	return nil
+}
+
+// subquery 对filter中的子路径求值, 子路径中的下标越界不算错误
+func (e *evaluator) subquery(q *query, current interface{}, emit func(node) bool) {
+	legacy, strict, missing := e.legacy, e.strict, e.missing
+	e.legacy, e.strict, e.missing = false, false, false
+	e.run(q, current, emit)
+	e.legacy, e.strict, e.missing = legacy, strict, missing
+}
+
+// value 对comparable求值, 结果为ValueType, 没有值时返回nothing
+func (e *evaluator) value(x expr, current interface{}) interface{} {
+	switch x := x.(type) {
+	case literalExpr:
+		return x.value
+	case queryExpr:
+		res := interface{}(nothing)
+		e.subquery(x.q, current, func(n node) bool {
+			res = scalar(n.value)
+			return false
+		})
+		return res
+	case funcExpr:
+		return e.call(x, current)
+	}
+	return nothing
+}
+
+func (e *evaluator) nodes(x expr, current interface{}) []node {
+	switch x := x.(type) {
+	case queryExpr:
+		var res []node
+		e.subquery(x.q, current, func(n node) bool {
+			res = append(res, n)
+			return true
+		})
+		return res
+	case funcExpr:
+		res, _ := e.call(x, current).([]node)
+		return res
+	}
+	return nil
+}
+
+func (e *evaluator) call(f funcExpr, current interface{}) interface{} {
+	args := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		switch f.fn.params[i] {
+		case valueType:
+			args[i] = e.value(arg, current)
+		case logicalType:
+			args[i] = e.test(arg, current)
+		case nodesType:
+			args[i] = e.nodes(arg, current)
+		}
+	}
+	return f.fn.call(args)
+}
+
+// compareValues 按RFC 9535的规则比较两个ValueType
+func compareValues(left, right interface{}, op string) bool {
+	switch op {
+	case "==":
+		return equalValues(left, right)
+	case "!=":
+		return !equalValues(left, right)
+	case "<":
+		return lessValues(left, right)
+	case "<=":
+		return lessValues(left, right) || equalValues(left, right)
+	case ">":
+		return lessValues(right, left)
+	case ">=":
+		return lessValues(right, left) || equalValues(left, right)
+	}
+	return false
+}
+
+func equalValues(left, right interface{}) bool {
+	left, right = scalar(left), scalar(right)
+	if left == nothing || right == nothing {
+		return left == nothing && right == nothing
+	}
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if isNumeric(left) || isNumeric(right) {
+		c, ok := compareNumbers(left, right)
+		return ok && c == 0
+	}
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	if length, ok := arrayLen(left); ok {
+		rLength, ok := arrayLen(right)
+		if !ok || length != rLength {
+			return false
+		}
+		for i := 0; i < length; i++ {
+			if !equalValues(element(left, i), element(right, i)) {
+				return false
+			}
+		}
+		return true
+	}
+	if length, ok := objectLen(left); ok {
+		rLength, ok := objectLen(right)
+		if !ok || length != rLength {
+			return false
+		}
+		equal := true
+		children(node{value: left}, func(child node) bool {
+			v, ok := member(right, child.loc.key)
+			equal = ok && equalValues(child.value, v)
+			return equal
+		})
+		return equal
+	}
+	return false
+}
+
+func lessValues(left, right interface{}) bool {
+	left, right = scalar(left), scalar(right)
+	if c, ok := compareNumbers(left, right); ok {
+		return c < 0
+	}
+	ls, lok := left.(string)
+	rs, rok := right.(string)
+	return lok && rok && ls < rs
+}
+
+func isNumeric(v interface{}) bool {
+	_, ok := toFloat(v)
+	return ok
+}
+
+// compareNumbers 比较两个数字, 返回-1、0、1. 不都是float64时转换成big.Rat比较, json.Number、int64、uint64和big包中的数字不会丢失精度
+func compareNumbers(left, right interface{}) (int, bool) {
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return compareFloats(l, r)
+		}
+	}
+	l, lok := toRat(left)
+	r, rok := toRat(right)
+	if lok && rok {
+		return l.Cmp(r), true
+	}
+	// 指数太大的json.Number不转换成big.Rat
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return 0, false
+	}
+	return compareFloats(lf, rf)
+}
+
+func compareFloats(l, r float64) (int, bool) {
+	switch {
+	case l < r:
+		return -1, true
+	case l > r:
+		return 1, true
+	}
+	return 0, l == r
+}
+
+// maxRatExponent 限制json.Number转换成big.Rat时的指数, 避免1e999999999这样的数字占用大量内存
+const maxRatExponent = 1000
+
+// toRat 浮点数按最短的十进制表示转换, 所以8.95和json.Number("8.95")相等
+func toRat(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		if i := strings.IndexAny(string(n), "eE"); i >= 0 {
+			exp, err := strconv.Atoi(string(n)[i+1:])
+			if err != nil || exp > maxRatExponent || exp < -maxRatExponent {
+				return nil, false
+			}
+		}
+		return new(big.Rat).SetString(string(n))
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(strconv.FormatFloat(n, 'g', -1, 64))
+	case float32:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(strconv.FormatFloat(float64(n), 'g', -1, 32))
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int8:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int16:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int32:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint8:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint16:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint32:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint64:
+		return new(big.Rat).SetUint64(n), true
+	case *big.Int:
+		if n == nil {
+			return nil, false
+		}
+		return new(big.Rat).SetInt(n), true
+	case *big.Rat:
+		if n == nil {
+			return nil, false
+		}
+		return new(big.Rat).Set(n), true
+	case *big.Float:
+		if n == nil || n.IsInf() {
+			return nil, false
+		}
+		r, _ := n.Rat(nil)
+		return r, true
+	}
+	// 自定义的数字类型, 例如type Qty int
+	switch s := scalar(v).(type) {
+	case int64, uint64, float64:
+		return toRat(s)
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float32:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case *big.Int:
+		if n == nil {
+			return 0, false
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, true
+	case *big.Rat:
+		if n == nil {
+			return 0, false
+		}
+		f, _ := n.Float64()
+		return f, true
+	case *big.Float:
+		if n == nil {
+			return 0, false
+		}
+		f, _ := n.Float64()
+		return f, true
+	}
+	switch s := scalar(v).(type) {
+	case int64:
+		return float64(s), true
+	case uint64:
+		return float64(s), true
+	case float64:
+		return s, true
+	}
+	return 0, false
+}
+
+// member 取对象的成员, 支持key可以和字符串互相转换的map和struct, struct按json tag取字段
+func member(obj interface{}, key string) (interface{}, bool) {
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		v, ok := o[key]
+		return v, ok
+	case nil, []interface{}:
+		return nil, false
+	case *rawNode:
+		return o.member(key)
+	}
+	value := reflect.ValueOf(indirect(obj))
+	switch value.Kind() {
+	case reflect.Struct:
+		return structMember(value, key)
+	case reflect.Map:
+		k, ok := mapKey(value.Type().Key(), key)
+		if !ok {
+			return nil, false
+		}
+		v := value.MapIndex(k)
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+	return nil, false
+}
+
+func objectLen(obj interface{}) (int, bool) {
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		return len(o), true
+	case nil, []interface{}:
+		return 0, false
+	case *rawNode:
+		if !o.object {
+			return 0, false
+		}
+		return o.len(), true
+	}
+	value := reflect.ValueOf(indirect(obj))
+	switch value.Kind() {
+	case reflect.Struct:
+		n := 0
+		structFields(value, func(string, interface{}) bool {
+			n++
+			return true
+		})
+		return n, true
+	case reflect.Map:
+		if !isObjectKey(value.Type().Key()) {
+			return 0, false
+		}
+		return value.Len(), true
+	}
+	return 0, false
+}
+
+func arrayLen(obj interface{}) (int, bool) {
+	switch o := obj.(type) {
+	case []interface{}:
+		return len(o), true
+	case nil, map[string]interface{}, string:
+		return 0, false
+	case *rawNode:
+		if o.object {
+			return 0, false
+		}
+		return o.len(), true
+	}
+	value := reflect.ValueOf(indirect(obj))
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return 0, false
+	}
+	return value.Len(), true
+}
+
+func element(obj interface{}, idx int) interface{} {
+	switch o := obj.(type) {
+	case []interface{}:
+		return o[idx]
+	case *rawNode:
+		return o.element(idx)
+	}
+	return reflect.ValueOf(indirect(obj)).Index(idx).Interface()
+}
+
+// children 按文档顺序遍历数组元素或对象成员, 对象成员按key排序保证结果稳定
+func children(n node, emit func(node) bool) bool {
+	if length, ok := arrayLen(n.value); ok {
+		for i := 0; i < length; i++ {
+			if !emit(node{value: element(n.value, i), parent: n.value, loc: n.loc.element(i)}) {
+				return false
+			}
+		}
+		return true
+	}
+	if m, ok := n.value.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !emit(node{value: m[k], parent: n.value, loc: n.loc.member(k)}) {
+				return false
+			}
+		}
+		return true
+	}
+	if r, ok := n.value.(*rawNode); ok {
+		return r.object && r.children(n, emit)
+	}
+	value := reflect.ValueOf(indirect(n.value))
+	if value.Kind() == reflect.Struct {
+		return structFields(value, func(name string, v interface{}) bool {
+			return emit(node{value: v, parent: n.value, loc: n.loc.member(name)})
+		})
+	}
+	if _, ok := objectLen(n.value); !ok {
+		return true
+	}
+	keys := value.MapKeys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = formatKey(k)
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return names[order[i]] < names[order[j]]
+	})
+	for _, i := range order {
+		if !emit(node{value: value.MapIndex(keys[i]).Interface(), parent: n.value, loc: n.loc.member(names[i])}) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isObjectKey 和encoding/json一样, map的key可以是string、整数或者实现了encoding.TextMarshaler的类型
+func isObjectKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return t.Implements(textMarshalerType) && reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// formatKey 把map的key转换成json中的字符串
+func formatKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// mapKey 把路径中的key转换成map的key, 不能转换时ok为false, 例如map[int]T中的"a"
+func mapKey(t reflect.Type, key string) (reflect.Value, bool) {
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(key).Convert(t), true
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, false
+		}
+		return k.Elem(), true
+	}
+	k := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || k.OverflowInt(n) {
+			return reflect.Value{}, false
+		}
+		k.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || k.OverflowUint(n) {
+			return reflect.Value{}, false
+		}
+		k.SetUint(n)
+	default:
+		return reflect.Value{}, false
+	}
+	return k, true
+}
@@ -0,0 +1,640 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// expr 是filter表达式的语法树节点
+type expr interface{}
+
+type (
+	orExpr struct {
+		operands []expr
+	}
+	andExpr struct {
+		operands []expr
+	}
+	notExpr struct {
+		operand expr
+	}
+	cmpExpr struct {
+		op          string
+		left, right expr
+	}
+	// queryExpr 是filter中以@或$开头的子路径
+	queryExpr struct {
+		q *query
+	}
+	literalExpr struct {
+		value interface{}
+	}
+	funcExpr struct {
+		name string
+		fn   *function
+		args []expr
+	}
+	// legacyFilter 是ModeLegacy中的filter, 仍然按 lp op rp 三段式求值
+	legacyFilter struct {
+		raw         string
+		op          string
+		left, right expr // legacyValue、funcExpr或者literalExpr
+		pat         *regexp.Regexp
+	}
+	// legacyValue 是legacyFilter中以@或$开头的路径
+	legacyValue struct {
+		text string
+		q    *query
+	}
+)
+
+// parseLegacyFilter 解析原有语法的?(...), 括号里可以用&&、||、!和括号组合多个比较
+func (p *parser) parseLegacyFilter() (selector, error) {
+	p.pos++ // ?
+	p.skipBlank()
+	if p.peek() != '(' {
+		return selector{}, p.errorf("filter should be in ?(...) form")
+	}
+	inFilter := p.inFilter
+	p.inFilter = true
+	e, err := p.parseParen()
+	p.inFilter = inFilter
+	if err != nil {
+		return selector{}, err
+	}
+	return selector{op: filterType, filter: e}, nil
+}
+
+// parseLegacyCmp 解析原有语法的比较 lp op rp, 没有op时表示lp存在, 例如
+// @.isbn                 => @.isbn, exists
+// @.price <= $.expensive => @.price, <=, $.expensive
+// @.author == 'Nigel Rees' => @.author, ==, "Nigel Rees"
+// @.active == true       => @.active, ==, true
+// length(@.tags) > 2     => length(@.tags), >, 2
+// @.status in ['open', 'pending'] => @.status, in, [open pending]
+func (p *parser) parseLegacyCmp() (expr, error) {
+	start := p.pos
+	left, err := p.parseLegacyOperand()
+	if err != nil {
+		return nil, err
+	}
+	f := legacyFilter{op: "exists", left: left}
+	end := p.pos
+	p.skipBlank()
+	if op := p.parseLegacyOp(); op != "" {
+		f.op = op
+		p.skipBlank()
+		if op == "=~" {
+			rp, err := p.parseLegacyRegexp()
+			if err != nil {
+				return nil, err
+			}
+			if f.pat, err = regFilterCompile(rp); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			f.right = literalExpr{value: rp}
+		} else if f.right, err = p.parseLegacyOperand(); err != nil {
+			return nil, err
+		}
+		if l, ok := f.right.(literalExpr); ok && op == "empty" {
+			if _, ok := l.value.(bool); !ok {
+				return nil, p.errorf("right side of empty should be true or false")
+			}
+		}
+		end = p.pos
+		for _, operand := range []expr{f.left, f.right} {
+			if fn, ok := operand.(funcExpr); ok {
+				if err := p.checkComparable(fn); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else if fn, ok := left.(funcExpr); ok {
+		if err := p.checkLogical(fn); err != nil {
+			return nil, err
+		}
+	}
+	p.pos = end
+	f.raw = p.path[start:end]
+	return f, nil
+}
+
+func (p *parser) parseLegacyOp() string {
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if p.eatString(op) {
+			return op
+		}
+	}
+	start := p.pos
+	for isLower(p.peek()) {
+		p.pos++
+	}
+	switch op := p.path[start:p.pos]; op {
+	case "in", "nin", "subsetof", "anyof", "noneof", "contains", "size", "empty":
+		if c := p.peek(); !isDigit(c) && c != '_' && !isNameFirst(p.peekRune()) {
+			return op
+		}
+	}
+	p.pos = start
+	return ""
+}
+
+// parseLegacyOperand 解析比较的一边: 路径、函数调用、数组或者literal
+func (p *parser) parseLegacyOperand() (expr, error) {
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		s, err := p.parseString()
+		return literalExpr{value: s}, err
+	case c == '[':
+		return p.parseLegacyList()
+	case c == '@' || c == '$':
+		p.pos++
+		q := &query{relative: c == '@'}
+		if err := p.parseSegments(q); err != nil {
+			return nil, err
+		}
+		return legacyValue{text: p.path[start:p.pos], q: q}, nil
+	case isLower(c):
+		for isLower(p.peek()) || isDigit(p.peek()) || p.peek() == '_' {
+			p.pos++
+		}
+		if p.peek() == '(' {
+			return p.parseFunction(p.path[start:p.pos])
+		}
+		p.pos = start
+	}
+	v, err := p.parseLegacyLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return literalExpr{value: v}, nil
+}
+
+// parseLegacyLiteral 解析没有引号的literal: json的数字、true、false、null,
+// 其他的内容直到空白、括号、运算符之前都作为字符串, 例如@.name == executor
+func (p *parser) parseLegacyLiteral() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.path) && !isFilterDelim(p.path[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos >= len(p.path) {
+			return nil, p.errorf("missing filter operand")
+		}
+		return nil, p.errorf("unexpected character %q", p.peekRune())
+	}
+	switch text := p.path[start:p.pos]; {
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case text == "null":
+		return nil, nil
+	case (text[0] == '-' || isDigit(text[0])) && json.Valid([]byte(text)):
+		return json.Number(text), nil
+	default:
+		return text, nil
+	}
+}
+
+// parseLegacyList 解析in、nin等运算符右边的数组, 例如['open', 'pending']和[1, 2]
+func (p *parser) parseLegacyList() (expr, error) {
+	p.pos++ // [
+	list := make([]interface{}, 0)
+	p.skipBlank()
+	if p.eat(']') {
+		return literalExpr{value: list}, nil
+	}
+	for {
+		p.skipBlank()
+		var (
+			item interface{}
+			err  error
+		)
+		if c := p.peek(); c == '\'' || c == '"' {
+			item, err = p.parseString()
+		} else {
+			item, err = p.parseLegacyLiteral()
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		p.skipBlank()
+		if p.eat(']') {
+			return literalExpr{value: list}, nil
+		}
+		if !p.eat(',') {
+			if p.pos >= len(p.path) {
+				return nil, p.errorf("missing ]")
+			}
+			return nil, p.errorf("unexpected character %q", p.peekRune())
+		}
+	}
+}
+
+// isFilterDelim 原有语法的filter中, 没有引号的值和key遇到这些字符结束
+func isFilterDelim(c byte) bool {
+	return strings.IndexByte(" \t\n\r(),]=!<>&|", c) >= 0
+}
+
+// legacyArg 把原有语法中的函数参数转换成RFC 9535的表达式, 路径作为query
+func legacyArg(e expr) expr {
+	f, ok := e.(legacyFilter)
+	if !ok || f.op != "exists" {
+		return e
+	}
+	if v, ok := f.left.(legacyValue); ok {
+		return queryExpr{q: v.q}
+	}
+	return f.left
+}
+
+// parseLegacyRegexp 解析=~右边的/pattern/
+func (p *parser) parseLegacyRegexp() (string, error) {
+	if p.peek() != '/' {
+		return "", p.errorf("invalid syntax. should be in `/pattern/` form")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.path) && p.path[p.pos] != '/'; p.pos++ {
+		if p.path[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	if !p.eat('/') {
+		return "", p.errorf("missing / of regular expression")
+	}
+	for isLower(p.peek()) {
+		p.pos++
+	}
+	return p.path[start:p.pos], nil
+}
+
+// formatLegacyExpr 把ModeLegacy的filter表达式还原成字符串
+func formatLegacyExpr(e expr) string {
+	switch e := e.(type) {
+	case orExpr:
+		parts := make([]string, 0, len(e.operands))
+		for _, operand := range e.operands {
+			parts = append(parts, formatLegacyExpr(operand))
+		}
+		return strings.Join(parts, " || ")
+	case andExpr:
+		parts := make([]string, 0, len(e.operands))
+		for _, operand := range e.operands {
+			if _, ok := operand.(orExpr); ok {
+				parts = append(parts, "("+formatLegacyExpr(operand)+")")
+			} else {
+				parts = append(parts, formatLegacyExpr(operand))
+			}
+		}
+		return strings.Join(parts, " && ")
+	case notExpr:
+		if f, ok := e.operand.(legacyFilter); ok {
+			return "!" + f.raw
+		}
+		return "!(" + formatLegacyExpr(e.operand) + ")"
+	case legacyFilter:
+		return e.raw
+	}
+	return ""
+}
+
+// parseLogical 解析logical-expr, ||的优先级低于&&, &&低于!
+func (p *parser) parseLogical() (expr, error) {
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkLogical(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	operands := []expr{left}
+	for {
+		start := p.pos
+		p.skipBlank()
+		if !p.eatString("||") {
+			p.pos = start
+			break
+		}
+		p.skipBlank()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	for _, operand := range operands {
+		if err := p.checkLogical(operand); err != nil {
+			return nil, err
+		}
+	}
+	return orExpr{operands: operands}, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseBasic()
+	if err != nil {
+		return nil, err
+	}
+	operands := []expr{left}
+	for {
+		start := p.pos
+		p.skipBlank()
+		if !p.eatString("&&") {
+			p.pos = start
+			break
+		}
+		p.skipBlank()
+		right, err := p.parseBasic()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	for _, operand := range operands {
+		if err := p.checkLogical(operand); err != nil {
+			return nil, err
+		}
+	}
+	return andExpr{operands: operands}, nil
+}
+
+// parseBasic 解析paren-expr、comparison-expr和test-expr
+func (p *parser) parseBasic() (expr, error) {
+	if p.peek() == '!' {
+		p.pos++
+		p.skipBlank()
+		var (
+			operand expr
+			err     error
+		)
+		switch {
+		case p.peek() == '(':
+			operand, err = p.parseParen()
+		case p.legacy:
+			operand, err = p.parseLegacyCmp()
+		default:
+			operand, err = p.parseComparable()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkLogical(operand); err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	if p.peek() == '(' {
+		return p.parseParen()
+	}
+	if p.legacy {
+		return p.parseLegacyCmp()
+	}
+
+	left, err := p.parseComparable()
+	if err != nil {
+		return nil, err
+	}
+	start := p.pos
+	p.skipBlank()
+	op := p.parseCmpOp()
+	if op == "" {
+		p.pos = start
+		return left, nil
+	}
+	if err := p.checkComparable(left); err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	right, err := p.parseComparable()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkComparable(right); err != nil {
+		return nil, err
+	}
+	return cmpExpr{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseParen() (expr, error) {
+	p.pos++ // (
+	p.skipBlank()
+	e, err := p.parseLogical()
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if !p.eat(')') {
+		return nil, p.errorf("missing )")
+	}
+	return e, nil
+}
+
+func (p *parser) parseCmpOp() string {
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.eatString(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseComparable 解析literal、子路径和函数调用
+func (p *parser) parseComparable() (expr, error) {
+	switch c := p.peek(); {
+	case c == '[' && p.legacy:
+		// RFC 9535中没有数组literal
+		return p.parseLegacyList()
+	case c == '@' || c == '$':
+		p.pos++
+		q := &query{relative: c == '@'}
+		if err := p.parseSegments(q); err != nil {
+			return nil, err
+		}
+		return queryExpr{q: q}, nil
+	case c == '\'' || c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return literalExpr{value: s}, nil
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case isLower(c):
+		start := p.pos
+		for isLower(p.peek()) || isDigit(p.peek()) || p.peek() == '_' {
+			p.pos++
+		}
+		name := p.path[start:p.pos]
+		if p.peek() == '(' {
+			return p.parseFunction(name)
+		}
+		switch name {
+		case "true":
+			return literalExpr{value: true}, nil
+		case "false":
+			return literalExpr{value: false}, nil
+		case "null":
+			return literalExpr{value: nil}, nil
+		}
+		p.pos = start
+		return nil, p.errorf("invalid literal %q", name)
+	case c == 0:
+		return nil, p.errorf("missing expression")
+	default:
+		return nil, p.errorf("unexpected character %q", p.peekRune())
+	}
+}
+
+// parseNumber 解析 (int / "-0") [ frac ] [ exp ]
+func (p *parser) parseNumber() (expr, error) {
+	start := p.pos
+	p.eat('-')
+	switch {
+	case p.eat('0'):
+		if isDigit(p.peek()) {
+			return nil, p.errorf("leading zeros are not allowed")
+		}
+	case isDigit(p.peek()):
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+	default:
+		return nil, p.errorf("invalid number")
+	}
+	if p.eat('.') {
+		if !isDigit(p.peek()) {
+			return nil, p.errorf("invalid fraction")
+		}
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+	}
+	if p.eat('e') || p.eat('E') {
+		if !p.eat('-') {
+			p.eat('+')
+		}
+		if !isDigit(p.peek()) {
+			return nil, p.errorf("invalid exponent")
+		}
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+	}
+	return literalExpr{value: json.Number(p.path[start:p.pos])}, nil
+}
+
+func (p *parser) parseFunction(name string) (expr, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	p.pos++ // (
+	p.skipBlank()
+	var args []expr
+	if p.peek() != ')' {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if p.legacy {
+				arg = legacyArg(arg)
+			}
+			args = append(args, arg)
+			p.skipBlank()
+			if !p.eat(',') {
+				break
+			}
+			p.skipBlank()
+		}
+	}
+	if !p.eat(')') {
+		return nil, p.errorf("missing ) of function %s", name)
+	}
+	if len(args) != len(fn.params) {
+		return nil, p.errorf("function %s expects %d arguments, got %d", name, len(fn.params), len(args))
+	}
+	for i, arg := range args {
+		var err error
+		switch fn.params[i] {
+		case valueType:
+			err = p.checkComparable(arg)
+		case logicalType:
+			err = p.checkLogical(arg)
+		case nodesType:
+			err = p.checkNodes(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return funcExpr{name: name, fn: fn, args: args}, nil
+}
+
+// checkLogical 检查表达式能否作为LogicalType使用
+func (p *parser) checkLogical(e expr) error {
+	switch e := e.(type) {
+	case orExpr, andExpr, notExpr, cmpExpr, queryExpr, legacyFilter:
+		return nil
+	case funcExpr:
+		if e.fn.result != valueType {
+			return nil
+		}
+		return p.errorf("result of function %s is not logical", e.name)
+	default:
+		return p.errorf("literal can not be used as a logical expression")
+	}
+}
+
+// checkComparable 检查表达式能否作为ValueType使用
+func (p *parser) checkComparable(e expr) error {
+	switch e := e.(type) {
+	case literalExpr:
+		return nil
+	case queryExpr:
+		if e.q.singular() {
+			return nil
+		}
+		return p.errorf("non-singular query is not comparable")
+	case funcExpr:
+		if e.fn.result == valueType {
+			return nil
+		}
+		return p.errorf("result of function %s is not comparable", e.name)
+	default:
+		return p.errorf("logical expression is not comparable")
+	}
+}
+
+// checkNodes 检查表达式能否作为NodesType使用
+func (p *parser) checkNodes(e expr) error {
+	switch e := e.(type) {
+	case queryExpr:
+		return nil
+	case funcExpr:
+		if e.fn.result == nodesType {
+			return nil
+		}
+		return p.errorf("result of function %s is not a nodelist", e.name)
+	default:
+		return p.errorf("expression is not a nodelist")
+	}
+}
@@ -0,0 +1,153 @@
+package jsonpath
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// funcType 是RFC 9535中函数参数和返回值的类型
+type funcType int
+
+const (
+	valueType funcType = iota
+	logicalType
+	nodesType
+)
+
+// function 是filter中可以调用的函数, call的参数已经按params转换好了:
+// valueType对应interface{}(可能是nothing), logicalType对应bool, nodesType对应[]node
+type function struct {
+	params []funcType
+	result funcType
+	call   func(args []interface{}) interface{}
+}
+
+var functions = map[string]*function{
+	"length": {params: []funcType{valueType}, result: valueType, call: lengthFunc},
+	"count":  {params: []funcType{nodesType}, result: valueType, call: countFunc},
+	"match":  {params: []funcType{valueType, valueType}, result: logicalType, call: matchFunc},
+	"search": {params: []funcType{valueType, valueType}, result: logicalType, call: searchFunc},
+	"value":  {params: []funcType{nodesType}, result: valueType, call: valueFunc},
+}
+
+// nothingType 表示ValueType中的Nothing, 和json的null区分开
+type nothingType struct{}
+
+var nothing = nothingType{}
+
+func lengthFunc(args []interface{}) interface{} {
+	switch v := args[0].(type) {
+	case string:
+		return utf8.RuneCountInString(v)
+	case nothingType, nil:
+		return nothing
+	}
+	if n, ok := arrayLen(args[0]); ok {
+		return n
+	}
+	if n, ok := objectLen(args[0]); ok {
+		return n
+	}
+	return nothing
+}
+
+func countFunc(args []interface{}) interface{} {
+	return len(args[0].([]node))
+}
+
+func valueFunc(args []interface{}) interface{} {
+	nodes := args[0].([]node)
+	if len(nodes) != 1 {
+		return nothing
+	}
+	return scalar(nodes[0].value)
+}
+
+func matchFunc(args []interface{}) interface{} {
+	return regexpFunc(args, true)
+}
+
+func searchFunc(args []interface{}) interface{} {
+	return regexpFunc(args, false)
+}
+
+func regexpFunc(args []interface{}, full bool) bool {
+	s, ok := args[0].(string)
+	if !ok {
+		return false
+	}
+	pattern, ok := args[1].(string)
+	if !ok {
+		return false
+	}
+	re, err := compileIRegexp(pattern, full)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+const maxCachedRegexps = 1024
+
+// regexpKey 区分match和search, 否则match的"abc"和search的"^abc"会共用一个缓存
+type regexpKey struct {
+	pattern string
+	full    bool
+}
+
+var (
+	regexpCache     = make(map[regexpKey]*regexp.Regexp)
+	regexpCacheLock sync.RWMutex
+)
+
+// compileIRegexp 把I-Regexp(RFC 9485)转成go的正则, full表示整体匹配.
+// I-Regexp里的.不匹配\n和\r, go的.只排除\n, 所以需要改写
+func compileIRegexp(pattern string, full bool) (*regexp.Regexp, error) {
+	key := regexpKey{pattern: pattern, full: full}
+	regexpCacheLock.RLock()
+	re, ok := regexpCache[key]
+	regexpCacheLock.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	var b strings.Builder
+	inClass := false
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\':
+			b.WriteRune(r)
+			if i+1 < len(runes) {
+				i++
+				b.WriteRune(runes[i])
+			}
+		case r == '[' && !inClass:
+			inClass = true
+			b.WriteRune(r)
+		case r == ']' && inClass:
+			inClass = false
+			b.WriteRune(r)
+		case r == '.' && !inClass:
+			b.WriteString(`[^\n\r]`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	expr := "(?:" + b.String() + ")"
+	if full {
+		expr = "^" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexpCacheLock.Lock()
+	if len(regexpCache) < maxCachedRegexps {
+		regexpCache[key] = re
+	}
+	regexpCacheLock.Unlock()
+	return re, nil
+}
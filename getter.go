@@ -0,0 +1,199 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// GetString 返回jsonPath选中的唯一一个值, 值必须是字符串
+func GetString(obj interface{}, jsonPath string) (string, error) {
+	return getAs[string](obj, jsonPath)
+}
+
+// GetInt64 返回jsonPath选中的唯一一个值, 值必须是整数, 例如json.Number("12")、12.0
+func GetInt64(obj interface{}, jsonPath string) (int64, error) {
+	return getAs[int64](obj, jsonPath)
+}
+
+// GetFloat64 返回jsonPath选中的唯一一个值, 值必须是数字
+func GetFloat64(obj interface{}, jsonPath string) (float64, error) {
+	return getAs[float64](obj, jsonPath)
+}
+
+// GetBool 返回jsonPath选中的唯一一个值, 值必须是true或false
+func GetBool(obj interface{}, jsonPath string) (bool, error) {
+	return getAs[bool](obj, jsonPath)
+}
+
+// GetSlice 返回jsonPath选中的唯一一个值, 值必须是数组
+func GetSlice(obj interface{}, jsonPath string) ([]interface{}, error) {
+	return getAs[[]interface{}](obj, jsonPath)
+}
+
+// GetMap 返回jsonPath选中的唯一一个值, 值必须是object
+func GetMap(obj interface{}, jsonPath string) (map[string]interface{}, error) {
+	return getAs[map[string]interface{}](obj, jsonPath)
+}
+
+// LookupAs 和Lookup一样, 但是把选中的值都转换成T. 数字可以在不丢失精度时相互转换, 例如json.Number("3")可以转换成int;
+// 任何一个值不能转换时返回*TypeError, 其中包含这个值的路径
+func LookupAs[T any](obj interface{}, jsonPath string) (map[string]T, error) {
+	res, err := Lookup(obj, jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[string]T, len(res))
+	for path, v := range res {
+		t, err := convertTo[T](path, v)
+		if err != nil {
+			return nil, err
+		}
+		typed[path] = t
+	}
+	return typed, nil
+}
+
+// getAs 要求jsonPath正好选中一个值
+func getAs[T any](obj interface{}, jsonPath string) (T, error) {
+	var zero T
+	matches, err := Query(obj, jsonPath)
+	if err != nil {
+		return zero, err
+	}
+	if len(matches) == 0 {
+		return zero, &NotFoundError{Path: jsonPath}
+	}
+	if len(matches) != 1 {
+		return zero, &MultipleMatchesError{Path: jsonPath, Count: len(matches)}
+	}
+	return convertTo[T](matches[0].Path, matches[0].Value)
+}
+
+// convertTo 把v转换成T, path只用于错误信息
+func convertTo[T any](path string, v interface{}) (T, error) {
+	var res T
+	if t, ok := v.(T); ok {
+		return t, nil
+	}
+	target := reflect.ValueOf(&res).Elem()
+	if !convertValue(target, v) {
+		return res, &TypeError{Path: path, Expected: target.Type().String(), Actual: typeName(v)}
+	}
+	return res, nil
+}
+
+var numberType = reflect.TypeOf(json.Number(""))
+
+// convertValue 数字之间可以相互转换, 转换成整数时要求是整数并且不溢出; 字符串、bool不会和数字互相转换
+func convertValue(target reflect.Value, v interface{}) bool {
+	if v == nil {
+		// null只能转换成可以为nil的类型
+		switch target.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			return true
+		}
+		return false
+	}
+	if target.Type() == numberType {
+		r, ok := toRat(v)
+		if !ok {
+			return false
+		}
+		if r.IsInt() {
+			target.SetString(r.Num().String())
+		} else {
+			f, _ := toFloat(v)
+			target.SetString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		return true
+	}
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		r, ok := toRat(v)
+		if !ok || !r.IsInt() || !r.Num().IsInt64() {
+			return false
+		}
+		n := r.Num().Int64()
+		if target.OverflowInt(n) {
+			return false
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		r, ok := toRat(v)
+		if !ok || !r.IsInt() || !r.Num().IsUint64() {
+			return false
+		}
+		n := r.Num().Uint64()
+		if target.OverflowUint(n) {
+			return false
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(v)
+		if !ok || math.IsInf(f, 0) || target.OverflowFloat(f) {
+			return false
+		}
+		target.SetFloat(f)
+	default:
+		if _, ok := v.(json.Number); ok {
+			return false
+		}
+		value := reflect.ValueOf(v)
+		if value.Type().ConvertibleTo(target.Type()) && value.Kind() == target.Kind() {
+			target.Set(value.Convert(target.Type()))
+			return true
+		}
+		switch target.Kind() {
+		case reflect.Slice:
+			return convertSlice(target, v)
+		case reflect.Map:
+			return convertMap(target, v)
+		}
+		return false
+	}
+	return true
+}
+
+// convertSlice 逐个转换数组的元素, 例如[]Item转换成[]interface{}
+func convertSlice(target reflect.Value, v interface{}) bool {
+	length, ok := arrayLen(v)
+	if !ok {
+		return false
+	}
+	res := reflect.MakeSlice(target.Type(), length, length)
+	for i := 0; i < length; i++ {
+		x, ok := valueOf(target.Type().Elem(), element(v, i))
+		if !ok {
+			return false
+		}
+		res.Index(i).Set(x)
+	}
+	target.Set(res)
+	return true
+}
+
+// convertMap 逐个转换object的成员, 例如map[string]string或者struct转换成map[string]interface{}
+func convertMap(target reflect.Value, v interface{}) bool {
+	if _, ok := objectLen(v); !ok {
+		return false
+	}
+	t := target.Type()
+	res := reflect.MakeMap(t)
+	ok := children(node{value: v}, func(child node) bool {
+		k, ok := mapKey(t.Key(), child.loc.key)
+		if !ok {
+			return false
+		}
+		x, ok := valueOf(t.Elem(), child.value)
+		if ok {
+			res.SetMapIndex(k, x)
+		}
+		return ok
+	})
+	if ok {
+		target.Set(res)
+	}
+	return ok
+}
@@ -1,70 +1,127 @@
 package jsonpath
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"go/token"
-	"go/types"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
-	keyType        = "key"
-	idxType        = "idx"
-	rangeType      = "range"
-	filterType     = "filter"
-	scanType       = "scan"
-	scanFilterType = "scanFilter"
-	rootType       = "root"
+	keyType    = "key"
+	idxType    = "idx"
+	rangeType  = "range"
+	filterType = "filter"
+	scanType   = "scan"
 )
 
-var (
-	errGetFromNullObj = errors.New("get attribute from null object")
-	errNotSupported   = errors.New("not supported")
-	reg1              = regexp.MustCompile(`\[([0-9]+|\*)]`)
-	reg2              = regexp.MustCompile(`[0-9]+]`)
-	reg3              = regexp.MustCompile("^\\${(.+)}$")
-)
+var reg3 = regexp.MustCompile("^\\${(.+)}$")
 
 func Lookup(obj interface{}, jsonPath string) (map[string]interface{}, error) {
-	c, err := compile(jsonPath)
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return c.Lookup(obj)
+}
+
+// LookupWithOptions 和Lookup一样, 但是可以通过opts选择方言, 例如Options{Mode: ModeRFC9535}
+func LookupWithOptions(obj interface{}, jsonPath string, opts Options) (map[string]interface{}, error) {
+	c, err := compileCached(jsonPath, opts)
 	if err != nil {
 		return nil, err
 	}
 	return c.Lookup(obj)
 }
 
-// SetToBody 给定一个JsonPath语法的固定路径，进行body更新.
+// LookupContext 和LookupWithOptions一样, 但是ctx取消或者超时时停止求值并返回ctx.Err().
+// 适合执行用户提供的jsonPath, 配合opts.Limits限制一次查找使用的资源
+func LookupContext(ctx context.Context, obj interface{}, jsonPath string, opts Options) (map[string]interface{}, error) {
+	c, err := compileCached(jsonPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.LookupContext(ctx, obj)
+}
+
+// Query 和Lookup一样, 但是按文档顺序返回选中的值, object的key按字典序遍历
+func Query(obj interface{}, jsonPath string) ([]Match, error) {
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return c.Select(obj)
+}
+
+// QueryWithOptions 和Query一样, 但是可以通过opts选择方言
+func QueryWithOptions(obj interface{}, jsonPath string, opts Options) ([]Match, error) {
+	c, err := compileCached(jsonPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.Select(obj)
+}
+
+// Exists 返回jsonPath是否选中了至少一个值, 不会遍历整个文档
+func Exists(obj interface{}, jsonPath string) (bool, error) {
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return false, err
+	}
+	return c.Exists(obj)
+}
+
+// Count 返回jsonPath选中的值的个数
+func Count(obj interface{}, jsonPath string) (int, error) {
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return 0, err
+	}
+	return c.Count(obj)
+}
+
+// First 返回jsonPath按文档顺序选中的第一个值, 没有选中时ok为false
+func First(obj interface{}, jsonPath string) (Match, bool, error) {
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return Match{}, false, err
+	}
+	return c.First(obj)
+}
+
+// SetToBody 给定一个JsonPath语法的固定路径，进行body更新. key中有特殊字符时可以写成$['a.b']["user name"].
+// value原样写入, 不会改变json.Number等数字的写法
 func SetToBody(body interface{}, keyFullPath string, value interface{}) error {
-	rawParts := strings.Split(keyFullPath, ".")
-	if len(rawParts) <= 1 || rawParts[0] != "$" {
-		return errors.New("invalid Key full path")
-	}
-	// parts数组中把"user[0]"拆成{"user","[0]"}存放,方便后续解析,支持连续多级,例如user[0][1][2]
-	parts := make([]string, 0, len(rawParts))
-	for _, rawPart := range rawParts {
-		strs := strings.Split(rawPart, "[")
-		for _, s := range strs {
-			ok := reg2.MatchString(s)
-			if ok {
-				s = "[" + s
-			}
-			parts = append(parts, s)
-		}
+	return SetToBodyWithOptions(body, keyFullPath, value, Options{})
+}
+
+// SetToBodyWithOptions 和SetToBody一样, opts.Policy决定路径不存在时是否返回错误
+func SetToBodyWithOptions(body interface{}, keyFullPath string, value interface{}, opts Options) error {
+	parts, err := parseFullPath(keyFullPath)
+	if err != nil {
+		return err
 	}
-	if err := recursiveSet(parts[1:], body, value); err != nil {
+	container, index, loc, ok, err := locate(parts, body, newWritePolicy(opts.Policy, true))
+	if !ok {
 		return err
 	}
-	return nil
+	return setValue(container, parts[len(parts)-1].key, index, loc, value)
 }
 
 // DeleteByKey 给定一个JsonPath语法的通配路径，进行body删除
 func DeleteByKey(body interface{}, key string) error {
-	keyMap, err := Lookup(body, key)
+	return DeleteByKeyWithOptions(body, key, Options{})
+}
+
+// DeleteByKeyWithOptions 和DeleteByKey一样, opts对查找和删除都生效
+func DeleteByKeyWithOptions(body interface{}, key string, opts Options) error {
+	keyMap, err := LookupWithOptions(body, key, opts)
 	if err != nil {
 		return err
 	}
@@ -72,25 +129,63 @@ func DeleteByKey(body interface{}, key string) error {
 	for k, _ := range keyMap {
 		toDelete = append(toDelete, k)
 	}
-	return DeleteBody(body, toDelete)
+	return DeleteBodyWithOptions(body, toDelete, opts)
 }
 
 // DeleteBody 给定一组JsonPath语法的固定路径，进行body删除
 func DeleteBody(body interface{}, keyFullPaths []string) error {
+	return DeleteBodyWithOptions(body, keyFullPaths, Options{})
+}
+
+// DeleteBodyWithOptions 和DeleteBody一样, opts.Policy决定路径不存在时是否返回错误. 返回错误时body不会被修改
+func DeleteBodyWithOptions(body interface{}, keyFullPaths []string, opts Options) error {
+	// 先找到所有要删除的位置再标记, 不用担心删除过程中json结构会发生变化
+	p := newWritePolicy(opts.Policy, false)
+	marks := make([]mark, 0, len(keyFullPaths))
+	seen := make(map[string]bool, len(keyFullPaths))
 	for _, keyFullPath := range keyFullPaths {
-		if err := markBody(keyFullPath, body); err != nil {
+		m, ok, err := findMark(keyFullPath, body, p)
+		if err != nil {
+			return err
+		}
+		if ok && !seen[m.path] {
+			seen[m.path] = true
+			marks = append(marks, m)
+		}
+	}
+	// 先删除深层的值, 同一个数组中先删除下标大的元素, 这样剩下的路径仍然指向原来的位置
+	sort.SliceStable(marks, func(i, j int) bool {
+		if len(marks[i].parts) != len(marks[j].parts) {
+			return len(marks[i].parts) > len(marks[j].parts)
+		}
+		return marks[i].index > marks[j].index
+	})
+	for _, m := range marks {
+		if err := m.apply(body); err != nil {
 			return err
 		}
 	}
-	recursiveDelete(&body)
 	return nil
 }
 
 // Rename 给定一个json_path重命名的配置，修改body的key
 func Rename(body interface{}, renames RenamesConfig) error {
-	configs, maxLen := renames.parseConfig()
+	return RenameWithOptions(body, renames, Options{})
+}
+
+// RenameWithOptions 和Rename一样, opts.Policy决定From不存在时是否返回错误
+func RenameWithOptions(body interface{}, renames RenamesConfig, opts Options) error {
+	configs, maxLen, err := renames.parseConfig()
+	if err != nil {
+		return err
+	}
+	// Rename的路径总是按ModeLegacy解析. 重命名和修改、删除一样, PolicyRFC9535按PolicyStrict处理, 缺少的From也返回错误
+	opts = Options{Policy: opts.Policy}
+	if opts.Policy == PolicyRFC9535 {
+		opts.Policy = PolicyStrict
+	}
 	for i := 0; i < maxLen; i++ {
-		if err := renameIndex(configs, i, body); err != nil {
+		if err := renameIndex(configs, i, body, opts); err != nil {
 			return err
 		}
 	}
@@ -103,45 +198,32 @@ func ParseJsonTemplate(jsonStr string) (map[string][]string, error) {
 	if err := json.Unmarshal([]byte(jsonStr), &jsonBody); err != nil {
 		return nil, err
 	}
-	res := make(map[string][]string)
-	resMap, err := Lookup(jsonBody, "$.*")
+	c, err := compileCached("$..*", Options{})
 	if err != nil {
 		return nil, err
 	}
-	if err := parseTemplate(jsonBody, resMap, res); err != nil {
+	nodes, err := c.selectNodes(jsonBody)
+	if err != nil {
 		return nil, err
 	}
-	return res, nil
-}
-
-func parseTemplate(jsonBody, pathMap map[string]interface{}, res map[string][]string) error {
-	for path, value := range pathMap {
-		switch v := value.(type) {
-		case string:
-			// 判断是否符合正则
-			params := reg3.FindStringSubmatch(v)
-			if len(params) > 0 {
-				res[params[len(params)-1]] = append(res[params[len(params)-1]], path)
-			}
-		case []interface{}, map[string]interface{}:
-			nextPathMap, err := Lookup(jsonBody, path+".*")
-			if err != nil {
-				return err
-			}
-			if err := parseTemplate(jsonBody, nextPathMap, res); err != nil {
-				return err
-			}
-		default:
-			// 非数组、map、string不用处理
+	// 按文档顺序遍历, 同一个名字的多个路径顺序是固定的
+	res := make(map[string][]string)
+	for _, n := range nodes {
+		v, ok := n.value.(string)
+		if !ok {
+			continue
+		}
+		if params := reg3.FindStringSubmatch(v); len(params) > 0 {
+			res[params[len(params)-1]] = append(res[params[len(params)-1]], n.loc.legacy())
 		}
 	}
-	return nil
+	return res, nil
 }
 
-func renameIndex(configs []renameConfigParse, k int, body interface{}) error {
+func renameIndex(configs []renameConfigParse, k int, body interface{}, opts Options) error {
 	renameMap := make(map[string]string)
 	for _, each := range configs {
-		err := renameEachWithIndex(body, each, k, renameMap)
+		err := renameEachWithIndex(body, each, k, renameMap, opts)
 		if err != nil {
 			return err
 		}
@@ -149,7 +231,7 @@ func renameIndex(configs []renameConfigParse, k int, body interface{}) error {
 	return nil
 }
 
-func renameEachWithIndex(body interface{}, config renameConfigParse, k int, renameMap map[string]string) error {
+func renameEachWithIndex(body interface{}, config renameConfigParse, k int, renameMap map[string]string, opts Options) error {
 	from, to, ok := config.buildPath(k)
 	if !ok {
 		return nil
@@ -161,19 +243,22 @@ func renameEachWithIndex(body interface{}, config renameConfigParse, k int, rena
 		return nil
 	}
 
-	doFrom, doTo := trim(from, to)
+	doFrom, doTo, err := trim(from, to)
+	if err != nil {
+		return err
+	}
 	if doFrom == doTo {
 		return nil
 	}
-	values, err := Lookup(body, doFrom)
+	values, err := LookupWithOptions(body, doFrom, opts)
 	if err != nil {
 		return err
 	}
 
-	if err := addBody(doTo, body, values); err != nil {
+	if err := addBody(doTo, body, values, opts); err != nil {
 		return err
 	}
-	if err := DeleteByKey(body, doFrom); err != nil {
+	if err := DeleteByKeyWithOptions(body, doFrom, opts); err != nil {
 		return err
 	}
 	// 修改config
@@ -185,1082 +270,607 @@ func renameEachWithIndex(body interface{}, config renameConfigParse, k int, rena
 	return nil
 }
 
-func trim(from string, to string) (string, string) {
-	fs := strings.Split(from, ".")
-	ts := strings.Split(to, ".")
-	lastFrom := fs[len(fs)-1]
-	lastTo := ts[len(ts)-1]
-	fs[len(fs)-1] = strings.Split(lastFrom, "[")[0]
-	ts[len(ts)-1] = strings.Split(lastTo, "[")[0]
-	return strings.Join(fs, "."), strings.Join(ts, ".")
+// trim 去掉路径中最后一个key后面的下标、通配等部分, 例如$.store.book[*] => $.store.book
+func trim(from string, to string) (string, string, error) {
+	fs, err := parseQuery(from, ModeLegacy)
+	if err != nil {
+		return "", "", err
+	}
+	ts, err := parseQuery(to, ModeLegacy)
+	if err != nil {
+		return "", "", err
+	}
+	fs.segments = fs.segments[:lastKey(fs.segments)+1]
+	ts.segments = ts.segments[:lastKey(ts.segments)+1]
+	return formatSegments(fs.segments), formatSegments(ts.segments), nil
 }
 
-func addBody(path string, body interface{}, values map[string]interface{}) error {
-	last := getPathLast(path)
+func addBody(path string, body interface{}, values map[string]interface{}, opts Options) error {
+	last, err := getPathLast(path)
+	if err != nil {
+		return err
+	}
 	setMap := make(map[string]interface{})
 	for k, v := range values {
-		newK := trimPathLast(k)
-		setMap[newK+"."+last] = v
+		newK, err := trimPathLast(k)
+		if err != nil {
+			return err
+		}
+		setMap[newK+formatName(last)] = v
 	}
 	for k, v := range setMap {
-		if err := SetToBody(body, k, v); err != nil {
+		if err := SetToBodyWithOptions(body, k, v, opts); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func trimPathLast(path string) string {
-	parts := strings.Split(path, ".")
-	return strings.Join(parts[0:len(parts)-1], ".")
-}
-
-func getPathLast(path string) string {
-	parts := strings.Split(path, ".")
-	return parts[len(parts)-1]
-}
-
-func markBody(keyFullPath string, body interface{}) error {
-	rawParts := strings.Split(keyFullPath, ".")
-	if len(rawParts) <= 1 || rawParts[0] != "$" {
-		return errors.New("invalid Key full path")
-	}
-	// parts数组中把"user[0]"拆成{"user","[0]"}存放,方便后续解析,支持连续多级,例如user[0][1][2]
-	parts := make([]string, 0, len(rawParts))
-	for _, rawPart := range rawParts {
-		strs := strings.Split(rawPart, "[")
-		for _, s := range strs {
-			ok := reg2.MatchString(s)
-			if ok {
-				s = "[" + s
-			}
-			parts = append(parts, s)
-		}
-	}
-	if err := recursiveMark(parts[1:], body); err != nil {
-		return err
+// trimPathLast 去掉路径中的最后一个key, 例如$.store.book => $.store
+func trimPathLast(path string) (string, error) {
+	q, err := parseQuery(path, ModeLegacy)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return formatSegments(q.segments[:lastKey(q.segments)]), nil
 }
 
-func recursiveMark(parts []string, body interface{}) error {
-	// 进来的part只有两种情况，一种是key值，一种是数组值[0]
-	params := reg1.FindStringSubmatch(parts[0])
-	switch len(params) {
-	case 2: //  数组
-		bodyArr, _ := body.([]interface{})
-		index, _ := strconv.Atoi(params[1])
-		if !(0 <= index && index < len(bodyArr)) {
-			return nil
-		}
-		if len(parts) == 1 {
-			bodyArr[index] = nil
-
-			return nil
-		}
-		return recursiveMark(parts[1:], bodyArr[index])
-	default: // Key
-		bodyMap, ok := body.(map[string]interface{})
-		if !ok {
-			return nil
-		}
-		if len(parts) == 1 {
-			bodyMap[parts[0]] = nil
-
-			return nil
-		}
-		return recursiveMark(parts[1:], bodyMap[parts[0]])
+// getPathLast 返回路径中的最后一个key
+func getPathLast(path string) (string, error) {
+	q, err := parseQuery(path, ModeLegacy)
+	if err != nil {
+		return "", err
 	}
-}
-
-func recursiveDelete(body interface{}) {
-	switch node := body.(type) {
-	case nil:
-		return
-	case *map[string]interface{}:
-		for k, v := range *node {
-			if v == nil {
-				delete(*node, k)
-			} else {
-				recursiveDelete(&v)
-				(*node)[k] = v
-			}
-		}
-	case *interface{}:
-		switch n1 := (*node).(type) {
-		case map[string]interface{}:
-			recursiveDelete(&n1)
-		case []interface{}:
-			arr := make([]interface{}, 0, len(n1))
-			for _, each := range n1 {
-				if each != nil {
-					arr = append(arr, each)
-				}
-			}
-			for _, each := range arr {
-				recursiveDelete(&each)
-			}
-			*node = arr
-		default:
-		}
-	default:
-		return
+	i := lastKey(q.segments)
+	if i < 0 {
+		return "", ErrInvalidPath
 	}
+	return q.segments[i].selectors[0].key, nil
 }
 
-func recursiveSet(parts []string, body interface{}, value interface{}) error {
-	// 进来的part只有两种情况，一种是key值，一种是数组值[0]
-	params := reg1.FindStringSubmatch(parts[0])
-	switch len(params) {
-	case 2: //  数组
-		bodyArr, _ := body.([]interface{})
-		index, _ := strconv.Atoi(params[1])
-		if !(0 <= index && index < len(bodyArr)) {
-			return errors.New("JsonPath error")
-		}
-		if len(parts) == 1 {
-			bodyArr[index] = value
-
-			return nil
-		}
-		return recursiveSet(parts[1:], bodyArr[index], value)
-	default: // Key
-		bodyMap, ok := body.(map[string]interface{})
-		if !ok {
-			return nil
-		}
-		if len(parts) == 1 {
-			bodyMap[parts[0]] = value
-
-			return nil
+// lastKey 返回最后一个只包含单个key的segment的下标, 没有则返回-1
+func lastKey(segs []segment) int {
+	for i := len(segs) - 1; i >= 0; i-- {
+		if segs[i].isKey() {
+			return i
 		}
-		return recursiveSet(parts[1:], bodyMap[parts[0]], value)
 	}
+	return -1
 }
 
-type step struct {
-	op   string
-	key  string
-	args interface{}
-}
-
-func compile(jsonPath string) (*compiled, error) {
-	tokens, err := tokenize(jsonPath)
-	if err != nil {
-		return nil, err
+// parseFullPath 把固定路径解析成由key和idx组成的selector
+func parseFullPath(keyFullPath string) ([]selector, error) {
+	if !strings.HasPrefix(keyFullPath, "$") {
+		return nil, ErrInvalidPath
 	}
-	if tokens[0] != "@" && tokens[0] != "$" {
-		return nil, fmt.Errorf("$ or @ should in front of path")
+	q, err := parseQuery(keyFullPath, ModeLegacy)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrInvalidPath, err)
 	}
-	tokens = tokens[1:]
-	res := compiled{
-		path:  jsonPath,
-		steps: make([]step, len(tokens)),
+	if len(q.segments) == 0 || !q.singular() {
+		return nil, ErrInvalidPath
 	}
-	for i, t := range tokens {
-		op, key, args, err := parseToken(t)
-		if err != nil {
-			return nil, err
-		}
-		res.steps[i] = step{op, key, args}
+	parts := make([]selector, 0, len(q.segments))
+	for _, seg := range q.segments {
+		parts = append(parts, seg.selectors[0])
 	}
-	return &res, nil
+	return parts, nil
 }
 
-type compiled struct {
-	path   string
-	steps  []step
-	result map[string]interface{}
-	root   interface{}
+// writePolicy 是修改时对选不中的路径的处理方式
+type writePolicy struct {
+	index  bool // 下标越界、对非数组取下标时返回IndexError
+	strict bool // 缺少的key、类型不符时也返回错误
 }
 
-func (c *compiled) init(obj interface{}) {
-	c.result = make(map[string]interface{})
-	if len(c.steps) > 0 {
-		c.result["$"] = obj
-		c.root = obj
+func newWritePolicy(policy Policy, set bool) writePolicy {
+	switch policy {
+	case PolicyStrict, PolicyRFC9535:
+		return writePolicy{index: true, strict: true}
+	case PolicyLenient:
+		return writePolicy{}
 	}
+	// PolicyDefault保留原有的行为: SetToBody下标越界时返回错误, 删除时跳过
+	return writePolicy{index: set}
 }
 
-func (c *compiled) String() string {
-	return fmt.Sprintf("compiled lookup: %s", c.path)
-}
-
-func (c *compiled) Lookup(obj interface{}) (map[string]interface{}, error) {
-	var err error
-	c.init(obj)
-	for _, s := range c.steps {
-		switch s.op {
-		case keyType:
-			err = c.getKeys(s)
-			if err != nil {
-				return nil, err
-			}
-		case idxType:
-			err = c.getIdxes(s)
-			if err != nil {
-				return nil, err
+// locate 沿着parts找到最后一部分所在的object或array, 数组的下标已经换算成非负数, loc是container的路径.
+// ok为false时表示按policy跳过, 或者返回了err
+func locate(parts []selector, body interface{}, p writePolicy) (container interface{}, index int, loc *location, ok bool, err error) {
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if part.op == idxType {
+			length, isArr := arrayLen(body)
+			if !isArr && p.strict {
+				return nil, 0, nil, false, &TypeError{Path: loc.normalized(), Expected: "array", Actual: typeName(body)}
 			}
-		case rangeType:
-			err = c.getRanges(s)
-			if err != nil {
-				return nil, err
+			index = part.index
+			if index < 0 {
+				index += length
 			}
-		case filterType:
-			err = c.filter(s)
-			if err != nil {
-				return nil, err
+			if index < 0 || index >= length {
+				if p.index {
+					return nil, 0, nil, false, &IndexError{Path: loc.normalized(), Index: part.index, Length: length}
+				}
+				return nil, 0, nil, false, nil
 			}
-		case scanFilterType:
-			err = c.scanFilter(s)
-			if err != nil {
-				return nil, err
+			if last {
+				return body, index, loc, true, nil
 			}
-		case scanType:
-			err = c.scans()
-			if err != nil {
-				return nil, err
+			body, loc = element(body, index), loc.element(index)
+			continue
+		}
+		if _, isObj := objectLen(body); !isObj {
+			if p.strict {
+				return nil, 0, nil, false, &TypeError{Path: loc.normalized(), Expected: "object", Actual: typeName(body)}
 			}
-		case rootType:
-			// todo
-		default:
-			return nil, errNotSupported
+			return nil, 0, nil, false, nil
 		}
-	}
-	return c.result, nil
-}
-
-func (c *compiled) scans() error {
-	res := make(map[string]interface{})
-	for k, v := range c.result {
-		obj, err := c.scan(v)
-		if err != nil {
-			return err
+		if last {
+			return body, 0, loc, true, nil
 		}
-		for oKey, oVal := range obj {
-			switch oKey.objType {
-			case reflect.Map:
-				res[k+"."+oKey.key] = oVal
-			case reflect.Slice:
-				res[k+"["+fmt.Sprintf("%d", oKey.index)+"]"] = oVal
-			default:
-
-			}
+		v, found := member(body, part.key)
+		if !found && p.strict {
+			return nil, 0, nil, false, &NotFoundError{Path: loc.member(part.key).normalized()}
 		}
+		body, loc = v, loc.member(part.key)
 	}
-	c.result = res
-	return nil
+	return nil, 0, nil, false, nil
 }
 
-func (c *compiled) scan(obj interface{}) (map[objType]interface{}, error) {
-	if obj == nil {
-		return nil, nil
-	}
-	res := make(map[objType]interface{})
-	value := reflect.ValueOf(obj)
-	switch value.Kind() {
-	case reflect.Slice:
-		length := value.Len()
-		for i := 0; i < length; i++ {
-			ot := objType{
-				objType: reflect.Slice,
-				index:   i,
-			}
-			res[ot] = value.Index(i).Interface()
+// setValue 把value写入container中的key或下标. container不是map[string]interface{}、[]interface{}时通过reflect写入,
+// value会按getter的规则转换成元素的类型
+func setValue(container interface{}, key string, index int, loc *location, value interface{}) error {
+	switch c := container.(type) {
+	case []interface{}:
+		c[index] = value
+		return nil
+	case map[string]interface{}:
+		if c != nil {
+			c[key] = value
+			return nil
+		}
+	}
+	v := indirectValue(container)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		elem := v.Index(index)
+		if !elem.CanSet() {
+			// 不是通过指针传入的数组不能修改
+			return &TypeError{Path: loc.normalized(), Expected: "slice", Actual: v.Type().String()}
+		}
+		x, ok := valueOf(elem.Type(), value)
+		if !ok {
+			return &TypeError{Path: loc.element(index).normalized(), Expected: elem.Type().String(), Actual: typeName(value)}
 		}
+		elem.Set(x)
+		return nil
 	case reflect.Map:
-		it := value.MapRange()
-		for it.Next() {
-			ot := objType{
-				objType: reflect.Map,
-				key:     it.Key().String(),
-			}
-			res[ot] = it.Value().Interface()
+		k, ok := mapKey(v.Type().Key(), key)
+		if !ok || v.IsNil() {
+			return &TypeError{Path: loc.normalized(), Expected: "map with key " + strconv.Quote(key), Actual: v.Type().String()}
 		}
-	default:
-		return nil, errNotSupported
+		x, ok := valueOf(v.Type().Elem(), value)
+		if !ok {
+			return &TypeError{Path: loc.member(key).normalized(), Expected: v.Type().Elem().String(), Actual: typeName(value)}
+		}
+		v.SetMapIndex(k, x)
+		return nil
 	}
-	return res, nil
+	return &TypeError{Path: loc.normalized(), Expected: "map or slice", Actual: typeName(container)}
 }
 
-func (c *compiled) scanFilter(s step) error {
-	res := make(map[string]interface{})
-	if err := c.scans(); err != nil {
-		return err
-	}
-	for k, v := range c.result {
-		obj, err := c.getFiltered(v, s.args.(string))
-		if err != nil {
-			return err
-		}
-		for oKey, oVal := range obj {
-			switch oKey.objType {
-			case reflect.Slice:
-				res[k+"["+fmt.Sprintf("%d", oKey.index)+"]"] = oVal
-			case reflect.Map:
-				res[k+"."+oKey.key] = oVal
-			default:
-				return errNotSupported
-			}
-
+// indirectValue 和indirect一样, 但是返回reflect.Value, 通过指针取到的值可以修改
+func indirectValue(v interface{}) reflect.Value {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
 		}
+		value = value.Elem()
 	}
-	c.result = res
-	return nil
+	return value
 }
 
-func (c *compiled) filter(s step) error {
-	res := make(map[string]interface{})
-	if err := c.getKeys(s); err != nil {
-		return err
-	}
-	for k, v := range c.result {
-		obj, err := c.getFiltered(v, s.args.(string))
-		if err != nil {
-			return err
-		}
-		for oKey, oVal := range obj {
-			switch oKey.objType {
-			case reflect.Slice:
-				res[k+"["+fmt.Sprintf("%d", oKey.index)+"]"] = oVal
-			case reflect.Map:
-				res[k+"."+oKey.key] = oVal
-			default:
-				return errNotSupported
-			}
-
+// valueOf 把v转换成类型t, 可以直接赋值时原样使用, 否则按convertValue的规则转换
+func valueOf(t reflect.Type, v interface{}) (reflect.Value, bool) {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), true
 		}
+		return reflect.Value{}, false
 	}
-	c.result = res
-	return nil
+	if x := reflect.ValueOf(v); x.Type().AssignableTo(t) {
+		return x, true
+	}
+	x := reflect.New(t).Elem()
+	return x, convertValue(x, v)
 }
 
-func (c *compiled) getRanges(s step) error {
-	var err error
-	res := make(map[string]interface{})
-	if len(s.key) > 0 {
-		// no key `$[:1].test`
-		err = c.getKeys(s)
-		if err != nil {
-			return err
-		}
-	}
-	if args, ok := s.args.([2]interface{}); ok {
-		for k, v := range c.result {
-			obj, err := c.getRange(v, args[0], args[1])
-			if err != nil {
-				return err
-			}
-			for oKey, oVal := range obj {
-				switch oKey.objType {
-				case reflect.Slice:
-					res[k+"["+strconv.Itoa(oKey.index)+"]"] = oVal
-				case reflect.Map:
-					res[k+"."+oKey.key] = oVal
-				default:
+// mark 是一个待删除的位置
+type mark struct {
+	parts []selector
+	key   string
+	index int
+	path  string // Normalized Path, 用于去重
+}
 
-				}
-			}
+func findMark(keyFullPath string, body interface{}, p writePolicy) (mark, bool, error) {
+	parts, err := parseFullPath(keyFullPath)
+	if err != nil {
+		return mark{}, false, err
+	}
+	container, index, loc, ok, err := locate(parts, body, p)
+	if !ok {
+		return mark{}, false, err
+	}
+	// 负数下标换算成实际下标, 否则前面的mark删除数组元素后, apply时会定位到别的元素
+	for i, l := range loc.elements() {
+		if l.isIndex {
+			parts[i].index = l.index
 		}
-		c.result = res
 	}
-	return nil
-}
-
-func (c *compiled) getKeys(s step) error {
-	res := make(map[string]interface{})
-	for k, obj := range c.result {
-		val, err := c.getKey(obj, s.key)
-		if err != nil {
-			return err
+	if last := &parts[len(parts)-1]; last.op == idxType {
+		last.index = index
+	}
+	m := mark{parts: parts, key: parts[len(parts)-1].key, index: index}
+	if _, isArr := arrayLen(container); isArr {
+		v := indirectValue(container)
+		if v.Kind() != reflect.Slice {
+			return mark{}, false, &TypeError{Path: loc.normalized(), Expected: "slice", Actual: v.Type().String()}
 		}
-		if val == nil {
-			continue
+		if len(parts) == 1 && !v.CanSet() {
+			// 删除后的数组需要写回$, 所以$本身是数组时要传入指针
+			return mark{}, false, &TypeError{Path: "$", Expected: "pointer to slice", Actual: v.Type().String()}
 		}
-		res[k+"."+s.key] = val
+		m.path = loc.element(index).normalized()
+		return m, true, nil
 	}
-	c.result = res
-	return nil
-}
-
-func (c *compiled) getIdxes(s step) error {
-	var (
-		err error
-	)
-	if len(s.key) > 0 {
-		// no key `$[0].test`
-		err = c.getKeys(s)
-		if err != nil {
-			return err
+	if _, found := member(container, m.key); !found {
+		if p.strict {
+			return mark{}, false, &NotFoundError{Path: loc.member(m.key).normalized()}
 		}
+		return mark{}, false, nil
 	}
-	if len(s.args.([]int)) > 0 {
-		res := make(map[string]interface{})
-		for _, x := range s.args.([]int) {
-			//fmt.Println("idx ---- ", x)
-			for k, v := range c.result {
-				tmp, err := c.getIdx(v, x)
-				if err != nil {
-					return err
-				}
-				res[k+"["+strconv.Itoa(x)+"]"] = tmp
-			}
-		}
-		c.result = res
+	if v := indirectValue(container); v.Kind() != reflect.Map {
+		return mark{}, false, &TypeError{Path: loc.normalized(), Expected: "map", Actual: typeName(container)}
 	}
-	return nil
+	m.path = loc.member(m.key).normalized()
+	return m, true, nil
 }
 
-func (c *compiled) getKey(obj interface{}, key string) (interface{}, error) {
-	if reflect.TypeOf(obj) == nil {
-		return nil, nil
+// apply 删除body中的这个位置. 删除数组元素时生成新的数组, 写回上一层的object或array
+func (m mark) apply(body interface{}) error {
+	container, index, loc, ok, err := locate(m.parts, body, writePolicy{})
+	if !ok {
+		return err
 	}
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Map:
-		// if obj came from stdlib json, its highly likely to be a map[string]interface{}
-		// in which case we can save having to iterate the map keys to work out if the
-		// key exists
-		if jsonMap, ok := obj.(map[string]interface{}); ok {
-			val, exists := jsonMap[key]
-			if !exists {
-				return nil, nil
-				//return fmt.Errorf("key error: %s not found in object", key)
-			}
-			return val, nil
-		}
-		return nil, nil
-	//case reflect.Slice:
-	//	// slice we should get from all objects in it.
-	//	res := []interface{}{}
-	//	for i := 0; i < reflect.ValueOf(obj).Len(); i++ {
-	//		tmp, _ := getIdx(obj, i)
-	//		if v, err := getKey(tmp, key); err == nil {
-	//			res = append(res, v)
-	//		}
-	//	}
-	//	//return res, nil
-	//	return nil
-	default:
-		return nil, nil
+	if c, isMap := container.(map[string]interface{}); isMap {
+		delete(c, m.key)
+		return nil
 	}
-}
-
-func (c *compiled) getIdx(obj interface{}, idx int) (interface{}, error) {
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Slice:
-		length := reflect.ValueOf(obj).Len()
-		if idx >= 0 {
-			if idx >= length {
-				return nil, fmt.Errorf("index out of range: len: %v, idx: %v", length, idx)
-			}
-			return reflect.ValueOf(obj).Index(idx).Interface(), nil
-		} else {
-			// < 0
-			_idx := length + idx
-			if _idx < 0 {
-				return nil, fmt.Errorf("index out of range: len: %v, idx: %v", length, idx)
-			}
-			return reflect.ValueOf(obj).Index(_idx).Interface(), nil
-		}
-	default:
-		return nil, fmt.Errorf("object is not Slice")
+	v := indirectValue(container)
+	if v.Kind() == reflect.Map {
+		k, _ := mapKey(v.Type().Key(), m.key)
+		v.SetMapIndex(k, reflect.Value{})
+		return nil
+	}
+	arr := reflect.MakeSlice(v.Type(), 0, v.Len()-1)
+	arr = reflect.AppendSlice(arr, v.Slice(0, index))
+	arr = reflect.AppendSlice(arr, v.Slice(index+1, v.Len()))
+	if len(m.parts) == 1 {
+		v.Set(arr)
+		return nil
+	}
+	parent, parentIndex, _, ok, err := locate(m.parts[:len(m.parts)-1], body, writePolicy{})
+	if !ok {
+		return err
 	}
+	return setValue(parent, m.parts[len(m.parts)-2].key, parentIndex, loc.parent, arr.Interface())
 }
 
-func (c *compiled) getRange(obj, frm, to interface{}) (map[objType]interface{}, error) {
-	res := make(map[objType]interface{})
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Slice:
-		length := reflect.ValueOf(obj).Len()
-		if length == 0 {
-			return nil, nil
-		}
-		_frm := 0
-		_to := length
-		if frm == nil {
-			frm = 0
-		}
-		if to == nil {
-			to = length - 1
-		}
-		if fv, ok := frm.(int); ok {
-			if fv < 0 {
-				_frm = length + fv
-			} else {
-				_frm = fv
-			}
-		}
-		if tv, ok := to.(int); ok {
-			if tv < 0 {
-				_to = length + tv + 1
-			} else {
-				_to = tv + 1
-			}
-		}
-		if _frm < 0 || _frm >= length {
-			return nil, fmt.Errorf("index [from] out of range: len: %v, from: %v", length, frm)
-		}
-		if _to < 0 || _to > length {
-			return nil, fmt.Errorf("index [to] out of range: len: %v, to: %v", length, to)
-		}
-		for i := _frm; i < _to; i++ {
-			v := reflect.ValueOf(obj).Index(i).Interface()
-			ot := objType{
-				objType: reflect.Slice,
-				index:   i,
-			}
-			res[ot] = v
-		}
-		return res, nil
-	case reflect.Map:
-		// must be *
-		if frm != nil || to != nil {
-			return nil, fmt.Errorf("get from map error")
-		}
-		it := reflect.ValueOf(obj).MapRange()
-		for it.Next() {
-			ot := objType{
-				objType: reflect.Map,
-				key:     it.Key().String(),
-			}
-			res[ot] = it.Value().Interface()
-		}
-		return res, nil
-	default:
-		return nil, fmt.Errorf("object is not supported")
-	}
+// Compile 解析jsonPath, 返回的Compiled可以重复使用, 也可以在多个goroutine中同时使用
+func Compile(jsonPath string) (*Compiled, error) {
+	return CompileWithOptions(jsonPath, Options{})
 }
 
-func (c *compiled) getFiltered(obj interface{}, filter string) (map[objType]interface{}, error) {
-	if obj == nil {
-		return nil, nil
+// CompileWithOptions 和Compile一样, 但是可以通过opts选择方言
+func CompileWithOptions(jsonPath string, opts Options) (*Compiled, error) {
+	if max := opts.Limits.MaxQueryLength; max > 0 && len(jsonPath) > max {
+		return nil, &LimitError{Limit: "query length", Max: max}
 	}
-	lp, op, rp, err := parseFilter(filter)
+	q, err := parseQuery(jsonPath, opts.Mode)
 	if err != nil {
 		return nil, err
 	}
+	return &Compiled{path: jsonPath, query: q, opts: opts}, nil
+}
 
-	res := make(map[objType]interface{})
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Slice:
-		if op == "=~" {
-			// regexp
-			pat, err := regFilterCompile(rp)
-			if err != nil {
-				return nil, err
-			}
-
-			for i := 0; i < reflect.ValueOf(obj).Len(); i++ {
-				tmp := reflect.ValueOf(obj).Index(i).Interface()
-				ok, err := evalRegFilter(tmp, c.root, lp, pat)
-				if err != nil {
-					return nil, err
-				}
-				if ok {
-					fo := objType{
-						objType: reflect.Slice,
-						index:   i,
-					}
-					res[fo] = tmp
-				}
-			}
-		} else {
-			for i := 0; i < reflect.ValueOf(obj).Len(); i++ {
-				tmp := reflect.ValueOf(obj).Index(i).Interface()
-				ok, err := evalFilter(tmp, c.root, lp, op, rp)
-				if err != nil {
-					return nil, err
-				}
-				if ok {
-					fo := objType{
-						objType: reflect.Slice,
-						index:   i,
-					}
-					res[fo] = tmp
-				}
-			}
-		}
-		return res, nil
-	case reflect.Map:
-		if op == "=~" {
-			// regexp
-			pat, err := regFilterCompile(rp)
-			if err != nil {
-				return nil, err
-			}
-
-			for _, kv := range reflect.ValueOf(obj).MapKeys() {
-				tmp := reflect.ValueOf(obj).MapIndex(kv).Interface()
-				ok, err := evalRegFilter(tmp, c.root, lp, pat)
-				if err != nil {
-					return nil, err
-				}
-				if ok {
-					fo := objType{
-						objType: reflect.Map,
-						key:     kv.String(),
-					}
-					res[fo] = tmp
-				}
-			}
-		} else {
-			for _, kv := range reflect.ValueOf(obj).MapKeys() {
-				tmp := reflect.ValueOf(obj).MapIndex(kv).Interface()
-				ok, err := evalFilter(tmp, c.root, lp, op, rp)
-				if err != nil {
-					return nil, err
-				}
-				if ok {
-					fo := objType{
-						objType: reflect.Map,
-						key:     kv.String(),
-					}
-					res[fo] = tmp
-				}
-			}
-		}
-	default:
-		return nil, nil
+// MustCompile 和Compile一样, 解析失败时panic, 适合初始化全局变量
+func MustCompile(jsonPath string) *Compiled {
+	c, err := Compile(jsonPath)
+	if err != nil {
+		panic(`jsonpath: Compile(` + strconv.Quote(jsonPath) + `): ` + err.Error())
 	}
-
-	return res, nil
+	return c
 }
 
-type objType struct {
-	objType reflect.Kind
-	index   int
-	key     string
+// Compiled 是解析后的jsonPath, 创建后不会再修改, 每次求值的状态都保存在单独的evaluator中
+type Compiled struct {
+	path  string
+	query *query
+	opts  Options
 }
 
-func tokenize(query string) ([]string, error) {
-	tokens := []string{}
-	//	token_start := false
-	//	token_end := false
-	t := ""
+func (c *Compiled) String() string {
+	return fmt.Sprintf("compiled lookup: %s", c.path)
+}
 
-	// fmt.Println("-------------------------------------------------- start")
-	for idx, x := range query {
-		t += string(x)
-		// //fmt.Printf("idx: %d, x: %s, token: %s, tokens: %v\n", idx, string(x), token, tokens)
-		if idx == 0 {
-			if t == "$" || t == "@" {
-				tokens = append(tokens, t[:])
-				t = ""
-				continue
-			} else {
-				return nil, fmt.Errorf("should start with '$'")
-			}
-		}
-		if t == "." {
-			continue
-		} else if t == ".." {
-			if tokens[len(tokens)-1] != "*" {
-				tokens = append(tokens, "*")
-			}
-			t = "."
-			continue
-		} else {
-			// fmt.Println("else: ", string(x), token)
-			if strings.Contains(t, "[") {
-				// fmt.Println(" contains [ ")
-				if x == ']' && !strings.HasSuffix(t, "\\]") {
-					if t[0] == '.' {
-						tokens = append(tokens, t[1:])
-					} else {
-						tokens = append(tokens, t[:])
-					}
-					t = ""
-					continue
-				}
-			} else {
-				// fmt.Println(" doesn't contains [ ")
-				if x == '.' {
-					if t[0] == '.' {
-						tokens = append(tokens, t[1:len(t)-1])
-					} else {
-						tokens = append(tokens, t[:len(t)-1])
-					}
-					t = "."
-					continue
-				}
-			}
-		}
-	}
-	if len(t) > 0 {
-		//if t[0] == '.' {
-		//	t = t[1:]
-		//	if t != "*" {
-		//		tokens = append(tokens, t[:])
-		//	} else if tokens[len(tokens)-1] != "*" {
-		//		tokens = append(tokens, t[:])
-		//	}
-		//} else {
-		//	if t != "*" {
-		//		tokens = append(tokens, t[:])
-		//	} else if tokens[len(tokens)-1] != "*" {
-		//		tokens = append(tokens, t[:])
-		//	}
-		//}
-		if t[0] == '.' {
-			t = t[1:]
-		}
-		tokens = append(tokens, t[:])
-	}
-	// fmt.Println("finished tokens: ", tokens)
-	// fmt.Println("================================================= done ")
-	return tokens, nil
+// Lookup 和jsonpath.Lookup一样, 省去了每次解析jsonPath的开销
+func (c *Compiled) Lookup(obj interface{}) (map[string]interface{}, error) {
+	return c.LookupContext(context.Background(), obj)
 }
 
-/*
- op: "root", "keyType", "idx", "range", "filter", "scan"
-*/
-func parseToken(token string) (op string, key string, args interface{}, err error) {
-	if token == "$" {
-		return rootType, "$", nil, nil
-	}
-	if token == "*" {
-		return scanType, "*", nil, nil
+// LookupContext 和Lookup一样, ctx取消或者超时时停止求值并返回ctx.Err()
+func (c *Compiled) LookupContext(ctx context.Context, obj interface{}) (map[string]interface{}, error) {
+	nodes, err := c.selectNodesContext(ctx, obj)
+	if err != nil {
+		return nil, err
 	}
-
-	bracketIdx := strings.Index(token, "[")
-	if bracketIdx < 0 {
-		return keyType, token, nil, nil
-	} else {
-		key = token[:bracketIdx]
-		tail := token[bracketIdx:]
-		if len(tail) < 3 {
-			err = fmt.Errorf("len(tail) should >=3, %v", tail)
-			return
-		}
-		tail = tail[1 : len(tail)-1]
-
-		//fmt.Println(key, tail)
-		if strings.Contains(tail, "?") {
-			// filter -------------------------------------------------
-			op = filterType
-			if strings.HasPrefix(tail, "?(") && strings.HasSuffix(tail, ")") {
-				args = strings.Trim(tail[2:len(tail)-1], " ")
-			}
-			if key == "*" {
-				op = scanFilterType
-			}
-			return
-		} else if strings.Contains(tail, ":") {
-			// range ----------------------------------------------
-			op = rangeType
-			tails := strings.Split(tail, ":")
-			if len(tails) != 2 {
-				err = fmt.Errorf("only support one range(from, to): %v", tails)
-				return
-			}
-			var frm interface{}
-			var to interface{}
-			if frm, err = strconv.Atoi(strings.Trim(tails[0], " ")); err != nil {
-				if strings.Trim(tails[0], " ") == "" {
-					err = nil
-				}
-				frm = nil
-			}
-			if to, err = strconv.Atoi(strings.Trim(tails[1], " ")); err != nil {
-				if strings.Trim(tails[1], " ") == "" {
-					err = nil
-				}
-				to = nil
-			}
-			args = [2]interface{}{frm, to}
-			return
-		} else if tail == "*" {
-			op = rangeType
-			args = [2]interface{}{nil, nil}
-			return
+	res := make(map[string]interface{}, len(nodes))
+	for _, n := range nodes {
+		if c.opts.Mode == ModeRFC9535 || c.opts.NormalizedPaths {
+			res[n.loc.normalized()] = n.value
 		} else {
-			// idx ------------------------------------------------
-			op = idxType
-			res := []int{}
-			for _, x := range strings.Split(tail, ",") {
-				if i, err := strconv.Atoi(strings.Trim(x, " ")); err == nil {
-					res = append(res, i)
-				} else {
-					return "", "", nil, err
-				}
-			}
-			args = res
+			res[n.loc.legacy()] = n.value
 		}
 	}
-	return op, key, args, nil
+	return res, nil
 }
 
-func filterGetFromExplicitPath(obj interface{}, path string) (interface{}, error) {
-	steps, err := tokenize(path)
-	//fmt.Println("f: steps: ", steps, err)
-	//fmt.Println(path, steps)
+// Select 按文档顺序返回选中的值. ModeLegacy和Lookup一样只保留重复节点中的第一个, ModeRFC9535保留重复的节点
+func (c *Compiled) Select(obj interface{}) ([]Match, error) {
+	nodes, err := c.selectNodes(obj)
 	if err != nil {
 		return nil, err
 	}
-	if steps[0] != "@" && steps[0] != "$" {
-		return nil, fmt.Errorf("$ or @ should in front of path")
-	}
-	steps = steps[1:]
-	xobj := obj
-	//fmt.Println("f: xobj", xobj)
-	for _, s := range steps {
-		op, key, args, err := parseToken(s)
-		// key, idx
-		switch op {
-		case keyType:
-			xobj, err = getKey(xobj, key)
-			if err != nil {
-				return nil, err
-			}
-		case idxType:
-			if len(args.([]int)) != 1 {
-				return nil, fmt.Errorf("don't support multiple index in filterType")
-			}
-			xobj, err = getKey(xobj, key)
-			if err != nil {
-				return nil, err
-			}
-			xobj, err = getIdx(xobj, args.([]int)[0])
-			if err != nil {
-				return nil, err
+	res := make([]Match, 0, len(nodes))
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		path := n.loc.normalized()
+		if c.opts.Mode == ModeLegacy {
+			if seen[path] {
+				continue
 			}
-		default:
-			return nil, fmt.Errorf("expression don't support in filterType")
+			seen[path] = true
 		}
+		res = append(res, newMatch(n, path))
 	}
-	return xobj, nil
+	return res, nil
 }
 
-func getKey(obj interface{}, key string) (interface{}, error) {
-	if reflect.TypeOf(obj) == nil {
-		return nil, errGetFromNullObj
-	}
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Map:
-		// if obj came from stdlib json, its highly likely to be a map[string]interface{}
-		// in which case we can save having to iterate the map keys to work out if the
-		// key exists
-		if jsonMap, ok := obj.(map[string]interface{}); ok {
-			val, exists := jsonMap[key]
-			if !exists {
-				return nil, fmt.Errorf("keyType error: %s not found in object", key)
-			}
-			return val, nil
-		}
-		for _, kv := range reflect.ValueOf(obj).MapKeys() {
-			//fmt.Println(kv.String())
-			if kv.String() == key {
-				return reflect.ValueOf(obj).MapIndex(kv).Interface(), nil
-			}
-		}
-		return nil, fmt.Errorf("keyType error: %s not found in object", key)
-	case reflect.Slice:
-		// slice we should get from all objects in it.
-		res := []interface{}{}
-		for i := 0; i < reflect.ValueOf(obj).Len(); i++ {
-			tmp, _ := getIdx(obj, i)
-			if v, err := getKey(tmp, key); err == nil {
-				res = append(res, v)
-			}
-		}
-		return res, nil
-	default:
-		return nil, fmt.Errorf("object is not map")
+// Exists 返回是否选中了至少一个值, 选中第一个值后就停止遍历
+func (c *Compiled) Exists(obj interface{}) (bool, error) {
+	found := false
+	err := c.walk(obj, func(node) bool {
+		found = true
+		return false
+	})
+	if found {
+		return true, nil
 	}
+	return false, err
 }
 
-func getIdx(obj interface{}, idx int) (interface{}, error) {
-	switch reflect.TypeOf(obj).Kind() {
-	case reflect.Slice:
-		length := reflect.ValueOf(obj).Len()
-		if idx >= 0 {
-			if idx >= length {
-				return nil, fmt.Errorf("index out of range: len: %v, idx: %v", length, idx)
-			}
-			return reflect.ValueOf(obj).Index(idx).Interface(), nil
-		} else {
-			// < 0
-			_idx := length + idx
-			if _idx < 0 {
-				return nil, fmt.Errorf("index out of range: len: %v, idx: %v", length, idx)
+// Count 返回选中的值的个数, 和Select的结果数量一致, 但是不保存选中的值
+func (c *Compiled) Count(obj interface{}) (int, error) {
+	count := 0
+	var seen map[string]bool
+	if c.opts.Mode == ModeLegacy {
+		seen = make(map[string]bool)
+	}
+	err := c.walk(obj, func(n node) bool {
+		if seen != nil {
+			path := n.loc.normalized()
+			if seen[path] {
+				return true
 			}
-			return reflect.ValueOf(obj).Index(_idx).Interface(), nil
+			seen[path] = true
 		}
-	default:
-		return nil, fmt.Errorf("object is not Slice")
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
 	}
+	return count, nil
 }
 
-func regFilterCompile(rule string) (*regexp.Regexp, error) {
-	runes := []rune(rule)
-	if len(runes) <= 2 {
-		return nil, errors.New("empty rule")
+// First 返回按文档顺序选中的第一个值, 没有选中时ok为false. 选中第一个值后就停止遍历
+func (c *Compiled) First(obj interface{}) (m Match, ok bool, err error) {
+	err = c.walk(obj, func(n node) bool {
+		m, ok = newMatch(n, n.loc.normalized()), true
+		return false
+	})
+	if ok {
+		return m, true, nil
 	}
+	return Match{}, false, err
+}
 
-	if runes[0] != '/' || runes[len(runes)-1] != '/' {
-		return nil, errors.New("invalid syntax. should be in `/pattern/` form")
+func newMatch(n node, path string) Match {
+	m := Match{Path: path, Value: n.value, Parent: n.parent}
+	if n.loc != nil {
+		if n.loc.isIndex {
+			m.Key = n.loc.index
+		} else {
+			m.Key = n.loc.key
+		}
 	}
-	runes = runes[1 : len(runes)-1]
-	return regexp.Compile(string(runes))
+	return m
 }
 
-// @.isbn                 => @.isbn, exists, nil
-// @.price < 10           => @.price, <, 10
-// @.price <= $.expensive => @.price, <=, $.expensive
-// @.author =~ /.*REES/i  => @.author, match, /.*REES/i
+// Match 是Query选中的一个值
+type Match struct {
+	Path   string      // Normalized Path, 例如$['store']['book'][0]
+	Value  interface{} // 选中的值
+	Parent interface{} // 值所在的object或array, 选中$时为nil
+	Key    interface{} // 值在Parent中的key(string)或下标(int), 选中$时为nil
+}
 
-func parseFilter(filter string) (lp string, op string, rp string, err error) {
-	tmp := ""
+// selectNodes 按文档顺序返回query选中的所有节点
+func (c *Compiled) selectNodes(obj interface{}) ([]node, error) {
+	return c.selectNodesContext(context.Background(), obj)
+}
 
-	stage := 0
-	strEmbrace := false
-	for idx, c := range filter {
-		switch c {
-		case '\'':
-			if strEmbrace == false {
-				strEmbrace = true
-			} else {
-				switch stage {
-				case 0:
-					lp = tmp
-				case 1:
-					op = tmp
-				case 2:
-					rp = tmp
-				}
-				tmp = ""
-			}
-		case ' ':
-			if strEmbrace == true {
-				tmp += string(c)
-				continue
-			}
-			switch stage {
-			case 0:
-				lp = tmp
-			case 1:
-				op = tmp
-			case 2:
-				rp = tmp
-			}
-			tmp = ""
+func (c *Compiled) selectNodesContext(ctx context.Context, obj interface{}) ([]node, error) {
+	var res []node
+	err := c.walkContext(ctx, obj, func(n node) bool {
+		res = append(res, n)
+		return true
+	})
+	return res, err
+}
 
-			stage += 1
-			if stage > 2 {
-				return "", "", "", errors.New(fmt.Sprintf("invalid char at %d: `%c`", idx, c))
-			}
-		default:
-			tmp += string(c)
-		}
+// walk 按文档顺序对选中的节点调用emit, emit返回false时停止遍历
+func (c *Compiled) walk(obj interface{}, emit func(node) bool) error {
+	return c.walkContext(context.Background(), obj, emit)
+}
+
+func (c *Compiled) walkContext(ctx context.Context, obj interface{}, emit func(node) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if tmp != "" {
-		switch stage {
-		case 0:
-			lp = tmp
-			op = "exists"
-		case 1:
-			op = tmp
-		case 2:
-			rp = tmp
+	e := c.newEvaluator(ctx, obj)
+	e.run(c.query, obj, func(n node) bool {
+		e.results++
+		if max := e.limits.MaxResults; max > 0 && e.results > max {
+			e.err = &LimitError{Limit: "results", Max: max}
+			return false
 		}
-		tmp = ""
+		return emit(n)
+	})
+	return e.err
+}
+
+func (c *Compiled) newEvaluator(ctx context.Context, root interface{}) *evaluator {
+	return &evaluator{
+		ctx:       ctx,
+		limits:    c.opts.Limits,
+		root:      root,
+		legacy:    c.opts.Mode == ModeLegacy,
+		strict:    c.opts.Policy == PolicyStrict || c.opts.Policy == PolicyDefault && c.opts.Mode == ModeLegacy,
+		missing:   c.opts.Policy == PolicyStrict,
+		inclusive: c.opts.Mode == ModeLegacy && c.opts.InclusiveSliceEnd,
 	}
-	return lp, op, rp, err
 }
 
-func evalRegFilter(obj, root interface{}, lp string, pat *regexp.Regexp) (res bool, err error) {
-	if pat == nil {
-		return false, errors.New("nil pat")
+func regFilterCompile(rule string) (*regexp.Regexp, error) {
+	runes := []rune(rule)
+	if len(runes) <= 2 {
+		return nil, errors.New("empty rule")
+	}
+
+	// 结尾可以带i、m、s标记, 例如/.*REES/i
+	end := len(runes) - 1
+	for end > 0 && strings.ContainsRune("ims", runes[end]) {
+		end--
 	}
-	lp_v, err := getLpV(obj, root, lp)
-	if err != nil {
-		return false, err
+	if runes[0] != '/' || end == 0 || runes[end] != '/' {
+		return nil, errors.New("invalid syntax. should be in `/pattern/` form")
 	}
-	switch v := lp_v.(type) {
-	case string:
-		return pat.MatchString(v), nil
-	default:
-		return false, errors.New("only string can match with regular expression")
+	pattern := string(runes[1:end])
+	if flags := string(runes[end+1:]); flags != "" {
+		pattern = "(?" + flags + ")" + pattern
 	}
+	return regexp.Compile(pattern)
 }
 
-func getLpV(obj, root interface{}, lp string) (interface{}, error) {
-	var lpV interface{}
-	if strings.HasPrefix(lp, "@.") {
-		return filterGetFromExplicitPath(obj, lp)
-	} else if strings.HasPrefix(lp, "$.") {
-		return filterGetFromExplicitPath(root, lp)
-	} else {
-		lpV = lp
-	}
-	return lpV, nil
-}
-
-func evalFilter(obj, root interface{}, lp, op, rp string) (res bool, err error) {
-	lp_v, err := getLpV(obj, root, lp)
-
-	if op == "exists" {
-		return lp_v != nil, nil
-	} else if op == "=~" {
-		return false, fmt.Errorf("not implemented yet")
-	} else {
-		var rp_v interface{}
-		if strings.HasPrefix(rp, "@.") {
-			rp_v, err = filterGetFromExplicitPath(obj, rp)
-		} else if strings.HasPrefix(rp, "$.") {
-			rp_v, err = filterGetFromExplicitPath(root, rp)
-		} else {
-			rp_v = rp
+// cmpAny 比较filter中的两个值, 数字按数值比较, 字符串按字典序比较, 类型不同的值不相等, 也不能比较大小
+func cmpAny(obj1, obj2 interface{}, op string) (bool, error) {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compareValues(obj1, obj2, op), nil
+	case "in":
+		list, ok := toList(obj2)
+		return ok && containsAny(list, obj1), nil
+	case "nin":
+		list, ok := toList(obj2)
+		return ok && !containsAny(list, obj1), nil
+	case "subsetof", "anyof", "noneof":
+		left, ok1 := toList(obj1)
+		right, ok2 := toList(obj2)
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		found := 0
+		for _, each := range left {
+			if containsAny(right, each) {
+				found++
+			}
+		}
+		switch op {
+		case "subsetof":
+			return found == len(left), nil
+		case "anyof":
+			return found > 0, nil
+		default:
+			return found == 0, nil
+		}
+	case "contains":
+		if s, ok := obj1.(string); ok {
+			sub, ok := obj2.(string)
+			return ok && strings.Contains(s, sub), nil
+		}
+		list, ok := toList(obj1)
+		return ok && containsAny(list, obj2), nil
+	case "size":
+		n, ok := sizeOf(obj1)
+		return ok && equalValues(n, obj2), nil
+	case "empty":
+		want, ok := obj2.(bool)
+		if !ok {
+			return false, fmt.Errorf("right side of empty should be true or false")
 		}
-		//fmt.Printf("lp_v: %v, rp_v: %v\n", lp_v, rp_v)
-		return cmpAny(lp_v, rp_v, op)
+		n, ok := sizeOf(obj1)
+		return ok && (n == 0) == want, nil
 	}
+	return false, fmt.Errorf("op should only be <, <=, ==, !=, >=, >, in, nin, subsetof, anyof, noneof, contains, size and empty")
 }
 
-func isNumber(o interface{}) bool {
-	switch v := o.(type) {
-	case int, int8, int16, int32, int64:
-		return true
-	case uint, uint8, uint16, uint32, uint64:
-		return true
-	case float32, float64:
-		return true
-	case string, json.Number:
-		_, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
-		if err == nil {
+func containsAny(list []interface{}, obj interface{}) bool {
+	for _, each := range list {
+		if equalValues(each, obj) {
 			return true
-		} else {
-			return false
 		}
 	}
 	return false
 }
 
-func cmpAny(obj1, obj2 interface{}, op string) (bool, error) {
-	switch op {
-	case "<", "<=", "==", ">=", ">":
-	default:
-		return false, fmt.Errorf("op should only be <, <=, ==, >= and >")
-	}
-
-	var exp string
-	if isNumber(obj1) && isNumber(obj2) {
-		exp = fmt.Sprintf(`%v %s %v`, obj1, op, obj2)
-	} else {
-		exp = fmt.Sprintf(`"%v" %s "%v"`, obj1, op, obj2)
-	}
-	//fmt.Println("exp: ", exp)
-	fset := token.NewFileSet()
-	res, err := types.Eval(fset, nil, 0, exp)
-	if err != nil {
-		return false, err
+// toList 把任意类型的slice转换成[]interface{}
+func toList(obj interface{}) ([]interface{}, bool) {
+	if list, ok := obj.([]interface{}); ok {
+		return list, true
 	}
-	if res.IsValue() == false || (res.Value.String() != "false" && res.Value.String() != "true") {
-		return false, fmt.Errorf("result should only be true or false")
+	n, ok := arrayLen(obj)
+	if !ok {
+		return nil, false
 	}
-	if res.Value.String() == "true" {
-		return true, nil
+	list := make([]interface{}, n)
+	for i := range list {
+		list[i] = element(obj, i)
 	}
+	return list, true
+}
 
-	return false, nil
+// sizeOf 返回字符串的字符数或者数组、对象的长度
+func sizeOf(obj interface{}) (int, bool) {
+	if s, ok := obj.(string); ok {
+		return utf8.RuneCountInString(s), true
+	}
+	if n, ok := arrayLen(obj); ok {
+		return n, true
+	}
+	return objectLen(obj)
 }
@@ -1,12 +1,18 @@
 package jsonpath
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
+	"io"
+	"math"
+	"math/big"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -260,7 +266,7 @@ func Test_jsonpath_JsonPathLookup_1(t *testing.T) {
 	})
 
 	t.Run("range", func(t *testing.T) {
-		res, err := Lookup(jsonData, "$.store.book[0:1].price")
+		res, err := Lookup(jsonData, "$.store.book[0:2].price")
 		assert.Nil(t, err)
 		assert.Equal(t, res, map[string]interface{}{
 			"$.store.book[0].price": json.Number("8.95"),
@@ -271,6 +277,32 @@ func Test_jsonpath_JsonPathLookup_1(t *testing.T) {
 	t.Run("range", func(t *testing.T) {
 		res, err := Lookup(jsonData, "$.store.book[0:1].title")
 		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+		})
+	})
+
+	t.Run("range with step", func(t *testing.T) {
+		res, err := Lookup(jsonData, "$.store.book[::2].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+			"$.store.book[2].title": "Moby Dick",
+		})
+	})
+
+	t.Run("range out of bound", func(t *testing.T) {
+		res, err := Lookup(jsonData, "$.store.book[2:100].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[2].title": "Moby Dick",
+			"$.store.book[3].title": "The Lord of the Rings",
+		})
+	})
+
+	t.Run("inclusive range", func(t *testing.T) {
+		res, err := LookupWithOptions(jsonData, "$.store.book[0:1].title", Options{InclusiveSliceEnd: true})
+		assert.Nil(t, err)
 		assert.Equal(t, res, map[string]interface{}{
 			"$.store.book[0].title": "Sayings of the Century",
 			"$.store.book[1].title": "Sword of Honour",
@@ -333,6 +365,358 @@ func Test_jsonpath_JsonPathLookup_filter(t *testing.T) {
 			"$.fields.任务执行人.value[0].id": "ou_debc524b2d8cb187704df652b43d29de",
 		})
 	})
+	t.Run("logical filter", func(t *testing.T) {
+		res, err := Lookup(jsonData, "$.store.book[?(@.price < 10 && @.category == 'fiction')].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[2].title": "Moby Dick",
+		})
+
+		res, err = Lookup(jsonData, "$.store.book[?(@.price > 20 || @.category == reference)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+			"$.store.book[3].title": "The Lord of the Rings",
+		})
+
+		res, err = Lookup(jsonData, "$.store.book[?(!@.isbn)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+			"$.store.book[1].title": "Sword of Honour",
+		})
+
+		// &&的优先级高于||, 括号可以改变优先级
+		res, err = Lookup(jsonData, "$.store.book[?(@.isbn && @.price < 10 || @.price > 20)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[2].title": "Moby Dick",
+			"$.store.book[3].title": "The Lord of the Rings",
+		})
+		res, err = Lookup(jsonData, "$.store.book[?(@.isbn && (@.price<10 || @.price>20))].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[2].title": "Moby Dick",
+			"$.store.book[3].title": "The Lord of the Rings",
+		})
+		res, err = Lookup(jsonData, "$.store.book[?(!(@.category == fiction) || @.author =~ /melville/i)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+			"$.store.book[2].title": "Moby Dick",
+		})
+
+		// 对map的每个value做filter
+		res, err = Lookup(jsonData, "$.store[?(@.color == red && @.price > 10)].price")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.bicycle.price": json.Number("19.95"),
+		})
+
+		// ||左边为true时不会再对右边求值
+		res, err = Lookup(jsonData, "$.store.book[?(@.price || @.price =~ /x/)].price")
+		assert.Nil(t, err)
+		assert.Len(t, res, 4)
+
+		_, err = Lookup(jsonData, "$.store.book[?(@.price < 10 && )]")
+		assert.NotNil(t, err)
+		_, err = Lookup(jsonData, "$.store.book[?((@.price < 10)]")
+		assert.NotNil(t, err)
+	})
+	t.Run("operator filter", func(t *testing.T) {
+		var body interface{}
+		_ = json.Unmarshal([]byte(`{"tickets": [
+    {"id": 1, "status": "open", "tags": ["bug", "ui"], "owner": "bob"},
+    {"id": 2, "status": "closed", "tags": [], "owner": "alice"},
+    {"id": 3, "status": "pending", "tags": ["bug"], "owner": "carol"}
+], "owners": ["bob", "carol"]}`), &body)
+		tcases := []struct {
+			path string
+			ids  []float64
+		}{
+			{path: "$.tickets[?(@.status != closed)].id", ids: []float64{1, 3}},
+			{path: "$.tickets[?(@.status in ['open','pending'])].id", ids: []float64{1, 3}},
+			{path: "$.tickets[?(@.status nin ['open', \"pending\"])].id", ids: []float64{2}},
+			{path: "$.tickets[?(@.id in [1, 2])].id", ids: []float64{1, 2}},
+			{path: "$.tickets[?(@.owner in $.owners)].id", ids: []float64{1, 3}},
+			{path: "$.tickets[?(@.tags subsetof ['bug'])].id", ids: []float64{2, 3}},
+			{path: "$.tickets[?(@.tags anyof ['ui', 'docs'])].id", ids: []float64{1}},
+			{path: "$.tickets[?(@.tags noneof ['ui'])].id", ids: []float64{2, 3}},
+			{path: "$.tickets[?(@.tags contains bug)].id", ids: []float64{1, 3}},
+			{path: "$.tickets[?(@.owner contains 'o')].id", ids: []float64{1, 3}},
+			{path: "$.tickets[?(@.tags size 2)].id", ids: []float64{1}},
+			{path: "$.tickets[?(@.owner size 5 || @.tags empty true)].id", ids: []float64{2, 3}},
+			{path: "$.tickets[?(@.tags empty false && !(@.status in [open]))].id", ids: []float64{3}},
+		}
+		for _, tcase := range tcases {
+			res, err := Lookup(body, tcase.path)
+			assert.Nil(t, err, tcase.path)
+			ids := make([]float64, 0, len(res))
+			for _, id := range res {
+				ids = append(ids, id.(float64))
+			}
+			sort.Float64s(ids)
+			assert.Equal(t, tcase.ids, ids, tcase.path)
+		}
+
+		_, err := Lookup(body, "$.tickets[?(@.status in ['open')].id")
+		assert.NotNil(t, err)
+		_, err = Lookup(body, "$.tickets[?(@.tags empty yes)].id")
+		assert.NotNil(t, err)
+	})
+	t.Run("sub query filter", func(t *testing.T) {
+		var body interface{}
+		_ = json.Unmarshal([]byte(`{"orders": [
+    {"id": 1, "tags": ["sale", "new"], "items": [{"sku": "a", "price": 50, "qty": 1}, {"sku": "b", "price": 150, "qty": 2}]},
+    {"id": 2, "tags": ["new"], "items": [{"sku": "c", "price": 20, "qty": 9}], "gift": {"price": 120}},
+    {"id": 3, "tags": [], "items": []}
+], "limit": {"qty": 5, "skus": ["a", "c"]}}`), &body)
+		tcases := []struct {
+			path string
+			ids  []float64
+		}{
+			{path: "$.orders[?(@.tags[?(@ == 'sale')])].id", ids: []float64{1}},
+			{path: "$.orders[?(@..price > 100)].id", ids: []float64{1, 2}},
+			{path: "$.orders[?(@.items[*].qty > $.limit.qty)].id", ids: []float64{2}},
+			{path: "$.orders[?(@.items[-1:].sku == b)].id", ids: []float64{1}},
+			{path: "$.orders[?(@.tags[*] == new && !@.gift)].id", ids: []float64{1}},
+			{path: "$.orders[?(@.items[?(@.price < 100 && @.qty > 1)])].id", ids: []float64{2}},
+			{path: "$.orders[?(@.items[*].sku anyof $.limit.skus)].id", ids: []float64{1, 2}},
+			{path: "$.orders[?(@.tags[0] in $.orders[*].tags[1])].id", ids: []float64{2}},
+			{path: "$.orders[?(@.*.price)].id", ids: []float64{2}},
+			{path: "$.orders[?(count(@.items[*]) == 0)].id", ids: []float64{3}},
+		}
+		for _, tcase := range tcases {
+			res, err := Lookup(body, tcase.path)
+			assert.Nil(t, err, tcase.path)
+			ids := make([]float64, 0, len(res))
+			for _, id := range res {
+				ids = append(ids, id.(float64))
+			}
+			sort.Float64s(ids)
+			assert.Equal(t, tcase.ids, ids, tcase.path)
+		}
+	})
+	t.Run("function filter", func(t *testing.T) {
+		res, err := Lookup(jsonData, "$.store.book[?(length(@.title) > 21)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+		})
+
+		res, err = Lookup(jsonData, `$.store.book[?(match(@.isbn, '0-\\d{3}-\\d{5}-3'))].title`)
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[2].title": "Moby Dick",
+		})
+
+		res, err = Lookup(jsonData, "$.store.book[?(search(@.author, 'Tolk') || count(@.*) < 5 && @.price < 10)].title")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].title": "Sayings of the Century",
+			"$.store.book[3].title": "The Lord of the Rings",
+		})
+
+		res, err = Lookup(jsonData, "$.store.book[?(!match(@.category, fiction) && value(@.author) == 'Nigel Rees')].price")
+		assert.Nil(t, err)
+		assert.Equal(t, res, map[string]interface{}{
+			"$.store.book[0].price": json.Number("8.95"),
+		})
+
+		res, err = Lookup(jsonData, "$.store[?(length(@) == length($.store.book))]")
+		assert.Nil(t, err)
+		assert.Len(t, res, 1)
+
+		// length的结果是ValueType, 不能单独作为条件
+		_, err = Lookup(jsonData, "$.store.book[?(length(@.title))]")
+		assert.NotNil(t, err)
+		// match的结果是LogicalType, 不能用来比较
+		_, err = Lookup(jsonData, "$.store.book[?(match(@.title, 'a') == true)]")
+		assert.NotNil(t, err)
+		_, err = Lookup(jsonData, "$.store.book[?(match(@.title))]")
+		assert.NotNil(t, err)
+		_, err = Lookup(jsonData, "$.store.book[?(count(@.title, @.price) > 1)]")
+		assert.NotNil(t, err)
+		_, err = Lookup(jsonData, "$.store.book[?(foo(@.title) > 1)]")
+		assert.NotNil(t, err)
+	})
+	t.Run("typed literal filter", func(t *testing.T) {
+		var body interface{}
+		_ = json.Unmarshal([]byte(`{"users": [
+    {"id": 1, "code": "007", "active": true, "deleted": null, "score": 100, "name": "a'b"},
+    {"id": 2, "code": 7, "active": "true", "score": -1.5, "name": "x\"y"},
+    {"id": 3, "code": "7", "active": false, "deleted": false, "score": 1e2, "name": "tab\there"}
+]}`), &body)
+		tcases := []struct {
+			path string
+			ids  []float64
+		}{
+			{path: "$.users[?(@.code == '007')].id", ids: []float64{1}},
+			{path: "$.users[?(@.code == 7)].id", ids: []float64{2}},
+			{path: `$.users[?(@.code == "7")].id`, ids: []float64{3}},
+			{path: "$.users[?(@.code == 7.0)].id", ids: []float64{2}},
+			{path: "$.users[?(@.active == true)].id", ids: []float64{1}},
+			{path: "$.users[?(@.active == 'true')].id", ids: []float64{2}},
+			{path: "$.users[?(@.active != false)].id", ids: []float64{1, 2}},
+			{path: "$.users[?(@.deleted == null)].id", ids: []float64{1}},
+			{path: "$.users[?(@.deleted)].id", ids: []float64{3}},
+			{path: "$.users[?(@.score == 1e2)].id", ids: []float64{1, 3}},
+			{path: "$.users[?(@.score < -1)].id", ids: []float64{2}},
+			{path: "$.users[?(@.score > '1')].id", ids: []float64{}},
+			{path: `$.users[?(@.name == 'a\'b')].id`, ids: []float64{1}},
+			{path: `$.users[?(@.name == "x\"y")].id`, ids: []float64{2}},
+			{path: `$.users[?(@.name == "tab\there")].id`, ids: []float64{3}},
+			{path: "$.users[?(@.code in [7, '7'])].id", ids: []float64{2, 3}},
+			{path: "$.users[?(@.active in [true, null])].id", ids: []float64{1}},
+		}
+		for _, tcase := range tcases {
+			res, err := Lookup(body, tcase.path)
+			assert.Nil(t, err, tcase.path)
+			ids := make([]float64, 0, len(res))
+			for _, id := range res {
+				ids = append(ids, id.(float64))
+			}
+			sort.Float64s(ids)
+			assert.Equal(t, tcase.ids, ids, tcase.path)
+		}
+
+		// 没有引号的字面量还是按字符串处理
+		res, err := Lookup(body, "$.users[?(@.code == 007)].id")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"$.users[0].id": float64(1)}, res)
+		// 大整数不会因为转换成float64丢失精度
+		decoder := json.NewDecoder(strings.NewReader(`[{"n": 9007199254740993}, {"n": 9007199254740992}]`))
+		decoder.UseNumber()
+		_ = decoder.Decode(&body)
+		res, err = Lookup(body, "$[?(@.n == 9007199254740993)]")
+		assert.Nil(t, err)
+		assert.Len(t, res, 1)
+	})
+}
+
+func Test_jsonpath_descendant(t *testing.T) {
+	res, err := Lookup(jsonData, "$..price")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[0].price": json.Number("8.95"),
+		"$.store.book[1].price": json.Number("12.99"),
+		"$.store.book[2].price": json.Number("8.99"),
+		"$.store.book[3].price": json.Number("22.99"),
+		"$.store.bicycle.price": json.Number("19.95"),
+	}, res)
+
+	res, err = Lookup(jsonData, "$..book[-1].title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[-1].title": "The Lord of the Rings",
+	}, res)
+
+	res, err = Lookup(jsonData, "$..[?(@.color)].color")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.bicycle.color": "red",
+	}, res)
+
+	res, err = Lookup(jsonData, "$..*")
+	assert.Nil(t, err)
+	assert.Len(t, res, 28)
+
+	var nested interface{}
+	_ = json.Unmarshal([]byte(`{
+    "shelf": {"book": [{"title": "a", "price": 1}], "box": [{"book": [{"title": "b", "price": 20}, {"title": "c"}]}]},
+    "book": [{"title": "d", "price": 5}]
+}`), &nested)
+	res, err = Lookup(nested, "$..book[?(@.price)].title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.book[0].title":              "d",
+		"$.shelf.book[0].title":        "a",
+		"$.shelf.box[0].book[0].title": "b",
+	}, res)
+
+	res, err = Lookup(nested, "$.shelf..book[1:]..title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.shelf.box[0].book[1].title": "c",
+	}, res)
+
+	// 子孙节点中下标越界的直接跳过
+	res, err = Lookup(nested, "$..[1].title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.shelf.box[0].book[1].title": "c",
+	}, res)
+
+	res, err = LookupWithOptions(nested, "$..book[0,1]['title']", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$['book'][0]['title']":                    "d",
+		"$['shelf']['book'][0]['title']":           "a",
+		"$['shelf']['box'][0]['book'][0]['title']": "b",
+		"$['shelf']['box'][0]['book'][1]['title']": "c",
+	}, res)
+
+	// ModeLegacy中连续的多个.和原来一样等价于..
+	want, err := Lookup(nested, "$..book")
+	assert.Nil(t, err)
+	res, err = Lookup(nested, "$...book")
+	assert.Nil(t, err)
+	assert.Equal(t, want, res)
+	res, err = Lookup(nested, "$....book")
+	assert.Nil(t, err)
+	assert.Equal(t, want, res)
+	_, err = LookupWithOptions(nested, "$...book", Options{Mode: ModeRFC9535})
+	assert.ErrorIs(t, err, ErrSyntax)
+}
+
+func Test_jsonpath_union(t *testing.T) {
+	res, err := Lookup(jsonData, "$.store.book[0]['title','author','nope']")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[0].title":  "Sayings of the Century",
+		"$.store.book[0].author": "Nigel Rees",
+	}, res)
+
+	// 多个selector选中同一个节点时, 结果中只保留一个
+	res, err = Lookup(jsonData, "$.store.book[0,2:4,-1].title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[0].title": "Sayings of the Century",
+		"$.store.book[2].title": "Moby Dick",
+		"$.store.book[3].title": "The Lord of the Rings",
+	}, res)
+
+	res, err = Lookup(jsonData, "$.store.book[?(@.isbn),?(@.price < 9)].title")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[0].title": "Sayings of the Century",
+		"$.store.book[2].title": "Moby Dick",
+		"$.store.book[3].title": "The Lord of the Rings",
+	}, res)
+
+	res, err = Lookup(jsonData, `$.store["bicycle",'book'][0,'color']`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.bicycle.color": "red",
+		"$.store.book[0]":       getValue(jsonData, "$.store.book[0]"),
+	}, res)
+
+	// RFC 9535模式中按RFC的规定保留重复的节点
+	c, err := CompileWithOptions("$.store.book[0,0,*].price", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	nodes, err := c.selectNodes(jsonData)
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 6)
+
+	res, err = Lookup(jsonData, "$.store.book[0,9].price")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$.store.book[0].price": json.Number("8.95"),
+	}, res)
+	_, err = Lookup(jsonData, "$.store.book[9]")
+	assert.NotNil(t, err)
+	_, err = Lookup(jsonData, "$.store.book[0,]")
+	assert.NotNil(t, err)
 }
 
 func Test_jsonpath_authors_of_all_books(t *testing.T) {
@@ -350,63 +734,63 @@ func Test_jsonpath_authors_of_all_books(t *testing.T) {
 var tokenCases = []map[string]interface{}{
 	{
 		"query":  "$..author",
-		"tokens": []string{"$", "*", "author"},
+		"tokens": "$..author",
 	},
 	{
 		"query":  "$.store.*",
-		"tokens": []string{"$", "store", "*"},
+		"tokens": "$.store[*]",
 	},
 	{
 		"query":  "$.store..price",
-		"tokens": []string{"$", "store", "*", "price"},
+		"tokens": "$.store..price",
 	},
 	{
 		"query":  "$.store.book[*].author",
-		"tokens": []string{"$", "store", "book[*]", "author"},
+		"tokens": "$.store.book[*].author",
 	},
 	{
 		"query":  "$..book[2]",
-		"tokens": []string{"$", "*", "book[2]"},
+		"tokens": "$..book[2]",
 	},
 	{
 		"query":  "$..book[(@.length-1)]",
-		"tokens": []string{"$", "*", "book[(@.length-1)]"},
+		"tokens": nil,
 	},
 	{
 		"query":  "$..book[0,1]",
-		"tokens": []string{"$", "*", "book[0,1]"},
+		"tokens": "$..book[0,1]",
 	},
 	{
 		"query":  "$..book[:2]",
-		"tokens": []string{"$", "*", "book[:2]"},
+		"tokens": "$..book[:2]",
 	},
 	{
 		"query":  "$..book[?(@.isbn)]",
-		"tokens": []string{"$", "*", "book[?(@.isbn)]"},
+		"tokens": "$..book[?(@.isbn)]",
 	},
 	{
 		"query":  "$.store.book[?(@.price < 10)]",
-		"tokens": []string{"$", "store", "book[?(@.price < 10)]"},
+		"tokens": "$.store.book[?(@.price < 10)]",
 	},
 	{
 		"query":  "$..book[?(@.price <= $.expensive)]",
-		"tokens": []string{"$", "*", "book[?(@.price <= $.expensive)]"},
+		"tokens": "$..book[?(@.price <= $.expensive)]",
 	},
 	{
 		"query":  "$..book[?(@.author =~ /.*REES/i)]",
-		"tokens": []string{"$", "*", "book[?(@.author =~ /.*REES/i)]"},
+		"tokens": "$..book[?(@.author =~ /.*REES/i)]",
 	},
 	{
 		"query":  "$..book[?(@.author =~ /.*REES\\]/i)]",
-		"tokens": []string{"$", "*", "book[?(@.author =~ /.*REES\\]/i)]"},
+		"tokens": "$..book[?(@.author =~ /.*REES\\]/i)]",
 	},
 	{
 		"query":  "$..*",
-		"tokens": []string{"$", "*", "*"},
+		"tokens": "$..*",
 	},
 	{
 		"query":  "$....author",
-		"tokens": []string{"$", "*", "author"},
+		"tokens": "$..author",
 	},
 }
 
@@ -415,10 +799,13 @@ func TestJsonpathTokenize(t *testing.T) {
 		for idx, tokenCase := range tokenCases {
 			t.Logf("idx[%d], tokenCase: %v", idx, tokenCase)
 			query := tokenCase["query"].(string)
-			expectedTokens := tokenCase["tokens"].([]string)
-			tokens, err := tokenize(query)
+			q, err := parseQuery(query, ModeLegacy)
+			if tokenCase["tokens"] == nil {
+				assert.NotNil(t, err)
+				continue
+			}
 			assert.Nil(t, err)
-			assert.Equal(t, tokens, expectedTokens)
+			assert.Equal(t, tokenCase["tokens"], formatSegments(q.segments))
 		}
 	})
 }
@@ -426,98 +813,88 @@ func TestJsonpathTokenize(t *testing.T) {
 var parseTokenCases = []map[string]interface{}{
 	{
 		"token": "$",
-		"op":    "root",
-		"key":   "$",
+		"op":    "",
 		"args":  nil,
 	},
 	{
-		"token": "store",
+		"token": "$.store",
 		"op":    "key",
-		"key":   "store",
-		"args":  nil,
+		"args":  "store",
 	},
 
 	// idx --------------------------------------
 	{
-		"token": "book[2]",
+		"token": "$.book[2]",
 		"op":    "idx",
-		"key":   "book",
 		"args":  []int{2},
 	},
 	{
-		"token": "book[-1]",
+		"token": "$.book[-1]",
 		"op":    "idx",
-		"key":   "book",
 		"args":  []int{-1},
 	},
 	{
-		"token": "book[0,1]",
+		"token": "$.book[0,1]",
 		"op":    "idx",
-		"key":   "book",
 		"args":  []int{0, 1},
 	},
 	{
-		"token": "[0]",
+		"token": "$[0]",
 		"op":    "idx",
-		"key":   "",
 		"args":  []int{0},
 	},
 
 	// range ------------------------------------
 	{
-		"token": "book[1:-1]",
+		"token": "$.book[1:-1]",
 		"op":    "range",
-		"key":   "book",
-		"args":  [2]interface{}{1, -1},
+		"args":  sliceArgs{start: 1, end: -1, step: 1, hasStart: true, hasEnd: true},
 	},
 	{
-		"token": "book[*]",
+		"token": "$.book[:2]",
 		"op":    "range",
-		"key":   "book",
-		"args":  [2]interface{}{nil, nil},
+		"args":  sliceArgs{end: 2, step: 1, hasEnd: true},
 	},
 	{
-		"token": "book[:2]",
+		"token": "$.book[-2:]",
 		"op":    "range",
-		"key":   "book",
-		"args":  [2]interface{}{nil, 2},
+		"args":  sliceArgs{start: -2, step: 1, hasStart: true},
 	},
 	{
-		"token": "book[-2:]",
+		"token": "$.book[::-1]",
 		"op":    "range",
-		"key":   "book",
-		"args":  [2]interface{}{-2, nil},
+		"args":  sliceArgs{step: -1},
 	},
 
 	// filter --------------------------------
 	{
-		"token": "book[?( @.isbn      )]",
+		"token": "$.book[?( @.isbn      )]",
 		"op":    "filter",
-		"key":   "book",
 		"args":  "@.isbn",
 	},
 	{
-		"token": "book[?(@.price < 10)]",
+		"token": "$.book[?(@.price < 10)]",
 		"op":    "filter",
-		"key":   "book",
 		"args":  "@.price < 10",
 	},
 	{
-		"token": "book[?(@.price <= $.expensive)]",
+		"token": "$.book[?(@.price <= $.expensive)]",
 		"op":    "filter",
-		"key":   "book",
 		"args":  "@.price <= $.expensive",
 	},
 	{
-		"token": "book[?(@.author =~ /.*REES/i)]",
+		"token": "$.book[?(@.author =~ /.*REES/i)]",
 		"op":    "filter",
-		"key":   "book",
 		"args":  "@.author =~ /.*REES/i",
 	},
 	{
-		"token": "*",
+		"token": "$.book[*]",
+		"op":    "scan",
+		"args":  nil,
+	},
+	{
+		"token": "$.*",
 		"op":    "scan",
-		"key":   "*",
 		"args":  nil,
 	},
 }
@@ -526,16 +903,31 @@ func TestJsonpathParseToken(t *testing.T) {
 	t.Run("parse token", func(t *testing.T) {
 		for idx, tokenCase := range parseTokenCases {
 			t.Logf("[%d] - tokenCase: %v", idx, tokenCase)
-			token := tokenCase["token"].(string)
-			expOp := tokenCase["op"].(string)
-			expKey := tokenCase["key"].(string)
-			expArgs := tokenCase["args"]
-
-			op, key, args, err := parseToken(token)
+			q, err := parseQuery(tokenCase["token"].(string), ModeLegacy)
 			assert.Nil(t, err)
-			assert.Equal(t, op, expOp)
-			assert.Equal(t, key, expKey)
-			assert.Equal(t, args, expArgs)
+			if tokenCase["op"] == "" {
+				assert.Empty(t, q.segments)
+				continue
+			}
+			// 只看最后一个segment
+			sels := q.segments[len(q.segments)-1].selectors
+			var args interface{}
+			switch tokenCase["op"] {
+			case keyType:
+				args = sels[0].key
+			case idxType:
+				idxes := make([]int, 0, len(sels))
+				for _, sel := range sels {
+					idxes = append(idxes, sel.index)
+				}
+				args = idxes
+			case rangeType:
+				args = sels[0].slice
+			case filterType:
+				args = formatLegacyExpr(sels[0].filter)
+			}
+			assert.Equal(t, tokenCase["op"], sels[0].op)
+			assert.Equal(t, tokenCase["args"], args)
 		}
 	})
 }
@@ -544,10 +936,9 @@ func TestJsonpathGetKey(t *testing.T) {
 	obj := map[string]interface{}{
 		"key": 1,
 	}
-	res, err := getKey(obj, "key")
-	fmt.Println(err, res)
-	if err != nil {
-		t.Errorf("failed to get key: %v", err)
+	res, ok := member(obj, "key")
+	if !ok {
+		t.Errorf("failed to get key")
 		return
 	}
 	if res.(int) != 1 {
@@ -555,9 +946,8 @@ func TestJsonpathGetKey(t *testing.T) {
 		return
 	}
 
-	res, err = getKey(obj, "hah")
-	fmt.Println(err, res)
-	if err == nil {
+	res, ok = member(obj, "hah")
+	if ok {
 		t.Errorf("key error not raised")
 		return
 	}
@@ -567,18 +957,15 @@ func TestJsonpathGetKey(t *testing.T) {
 	}
 
 	obj2 := 1
-	res, err = getKey(obj2, "key")
-	fmt.Println(err, res)
-	if err == nil {
-
+	res, ok = member(obj2, "key")
+	if ok {
 		t.Errorf("object is not map error not raised")
 		return
 	}
 	obj3 := map[string]string{"key": "hah"}
-	res, err = getKey(obj3, "key")
-	if res_v, ok := res.(string); ok != true || res_v != "hah" {
-		fmt.Println(err, res)
-		t.Errorf("map[string]string support failed")
+	res, ok = member(obj3, "key")
+	if res_v, _ := res.(string); ok != true || res_v != "hah" {
+		t.Errorf("map[string]string support failed: %v", res)
 	}
 
 	obj4 := []map[string]interface{}{
@@ -589,36 +976,36 @@ func TestJsonpathGetKey(t *testing.T) {
 			"a": 2,
 		},
 	}
-	res, err = getKey(obj4, "a")
-	fmt.Println(err, res)
+	// 数组没有key
+	_, ok = member(obj4, "a")
+	assert.False(t, ok)
 }
 
 func TestJsonpathGetIdx(t *testing.T) {
-	c := &compiled{}
 	obj := []interface{}{1, 2, 3, 4}
-	res, err := c.getIdx(obj, 0)
+	res, err := Lookup(obj, "$[0]")
 	assert.Nil(t, err)
-	assert.Equal(t, res, 1)
+	assert.Equal(t, res, map[string]interface{}{"$[0]": 1})
 
-	res, err = c.getIdx(obj, 2)
+	res, err = Lookup(obj, "$[2]")
 	assert.Nil(t, err)
-	assert.Equal(t, res, 3)
-	res, err = c.getIdx(obj, 4)
+	assert.Equal(t, res, map[string]interface{}{"$[2]": 3})
+	res, err = Lookup(obj, "$[4]")
 	assert.NotNil(t, err)
 
-	res, err = c.getIdx(obj, -1)
+	res, err = Lookup(obj, "$[-1]")
 	assert.Nil(t, err)
-	assert.Equal(t, res, 4)
+	assert.Equal(t, res, map[string]interface{}{"$[-1]": 4})
 
-	res, err = c.getIdx(obj, -4)
+	res, err = Lookup(obj, "$[-4]")
 	assert.Nil(t, err)
-	assert.Equal(t, res, 1)
+	assert.Equal(t, res, map[string]interface{}{"$[-4]": 1})
 
-	res, err = c.getIdx(obj, -5)
+	res, err = Lookup(obj, "$[-5]")
 	assert.NotNil(t, err)
 
 	obj1 := 1
-	res, err = c.getIdx(obj1, 1)
+	res, err = Lookup(obj1, "$[1]")
 	assert.NotNil(t, err)
 }
 
@@ -626,106 +1013,74 @@ func TestJsonpathGetRange(t *testing.T) {
 	type (
 		testCase struct {
 			obj    interface{}
-			frm    interface{}
-			to     interface{}
-			expRes map[objType]interface{}
+			path   string
+			opts   Options
+			expRes map[string]interface{}
 		}
 	)
 	cases := []testCase{
 		{
-			obj: []int{1, 2, 3, 4, 5},
-			frm: 0,
-			to:  2,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Slice,
-					index:   0,
-				}: 1,
-				{
-					objType: reflect.Slice,
-					index:   1,
-				}: 2,
-				{
-					objType: reflect.Slice,
-					index:   2,
-				}: 3,
-			},
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[0:2]",
+			expRes: map[string]interface{}{"$[0]": 1, "$[1]": 2},
 		},
 		{
-			obj: []int{1, 2, 3, 4, 5},
-			frm: 3,
-			to:  -1,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Slice,
-					index:   3,
-				}: 4,
-				{
-					objType: reflect.Slice,
-					index:   4,
-				}: 5,
-			},
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[3:-1]",
+			expRes: map[string]interface{}{"$[3]": 4},
 		},
 		{
-			obj: []int{1, 2, 3, 4, 5},
-			frm: nil,
-			to:  2,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Slice,
-					index:   0,
-				}: 1,
-				{
-					objType: reflect.Slice,
-					index:   1,
-				}: 2,
-				{
-					objType: reflect.Slice,
-					index:   2,
-				}: 3,
-			},
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[:2]",
+			expRes: map[string]interface{}{"$[0]": 1, "$[1]": 2},
 		},
 		{
-			obj: []int{1, 2, 3, 4, 5},
-			frm: nil,
-			to:  nil,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Slice,
-					index:   0,
-				}: 1,
-				{
-					objType: reflect.Slice,
-					index:   1,
-				}: 2,
-				{
-					objType: reflect.Slice,
-					index:   2,
-				}: 3,
-				{
-					objType: reflect.Slice,
-					index:   3,
-				}: 4,
-				{
-					objType: reflect.Slice,
-					index:   4,
-				}: 5,
-			},
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[:]",
+			expRes: map[string]interface{}{"$[0]": 1, "$[1]": 2, "$[2]": 3, "$[3]": 4, "$[4]": 5},
 		},
 		{
-			obj: []int{1, 2, 3, 4, 5},
-			frm: -2,
-			to:  nil,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Slice,
-					index:   3,
-				}: 4,
-				{
-					objType: reflect.Slice,
-					index:   4,
-				}: 5,
-			},
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[-2:]",
+			expRes: map[string]interface{}{"$[3]": 4, "$[4]": 5},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[1:10:2]",
+			expRes: map[string]interface{}{"$[1]": 2, "$[3]": 4},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[::-2]",
+			expRes: map[string]interface{}{"$[4]": 5, "$[2]": 3, "$[0]": 1},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[-10:10]",
+			expRes: map[string]interface{}{"$[0]": 1, "$[1]": 2, "$[2]": 3, "$[3]": 4, "$[4]": 5},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[3:1]",
+			expRes: map[string]interface{}{},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[0:2]",
+			opts:   Options{InclusiveSliceEnd: true},
+			expRes: map[string]interface{}{"$[0]": 1, "$[1]": 2, "$[2]": 3},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[3:-1]",
+			opts:   Options{InclusiveSliceEnd: true},
+			expRes: map[string]interface{}{"$[3]": 4, "$[4]": 5},
+		},
+		{
+			obj:    []int{1, 2, 3, 4, 5},
+			path:   "$[3:0:-1]",
+			opts:   Options{InclusiveSliceEnd: true},
+			expRes: map[string]interface{}{"$[3]": 4, "$[2]": 3, "$[1]": 2, "$[0]": 1},
 		},
 		{
 			obj: map[string]interface{}{
@@ -733,33 +1088,18 @@ func TestJsonpathGetRange(t *testing.T) {
 				"b": "b1",
 				"c": "c1",
 			},
-			frm: nil,
-			to:  nil,
-			expRes: map[objType]interface{}{
-				{
-					objType: reflect.Map,
-					key:     "a",
-				}: "a1",
-				{
-					objType: reflect.Map,
-					key:     "b",
-				}: "b1",
-				{
-					objType: reflect.Map,
-					key:     "c",
-				}: "c1",
-			},
+			path:   "$[:]",
+			expRes: map[string]interface{}{"$.a": "a1", "$.b": "b1", "$.c": "c1"},
 		},
 	}
-	c := &compiled{}
 	for _, oneCase := range cases {
-		res, err := c.getRange(oneCase.obj, oneCase.frm, oneCase.to)
-		assert.Nil(t, err)
-		assert.Equal(t, res, oneCase.expRes)
+		res, err := LookupWithOptions(oneCase.obj, oneCase.path, oneCase.opts)
+		assert.Nil(t, err, oneCase.path)
+		assert.Equal(t, oneCase.expRes, res, oneCase.path)
 	}
 
 	obj2 := 2
-	_, err := c.getRange(obj2, 0, 1)
+	_, err := Lookup(obj2, "$[0:1]")
 	assert.NotNil(t, err)
 }
 
@@ -806,14 +1146,30 @@ var testCaseParseFilter = []map[string]interface{}{
 	},
 }
 
+// TestJsonpathParseFilter 检查ModeLegacy的filter解析出的运算符和两边的操作数
 func TestJsonpathParseFilter(t *testing.T) {
 	for _, testCase := range testCaseParseFilter {
-		lp, op, rp, _ := parseFilter(testCase["filter"].(string))
-		t.Log(testCase)
-		t.Logf("lp: %v, op: %v, rp: %v", lp, op, rp)
-		assert.Equal(t, lp, testCase["exp_lp"])
-		assert.Equal(t, op, testCase["exp_op"])
-		assert.Equal(t, rp, testCase["exp_rp"])
+		filter := testCase["filter"].(string)
+		q, err := parseQuery("$[?("+filter+")]", ModeLegacy)
+		if !assert.Nil(t, err, filter) {
+			continue
+		}
+		f, ok := q.segments[0].selectors[0].filter.(legacyFilter)
+		if !assert.True(t, ok, filter) {
+			continue
+		}
+		assert.Equal(t, testCase["exp_op"], f.op, filter)
+		assert.Equal(t, testCase["exp_lp"], f.left.(legacyValue).text, filter)
+		switch rp := f.right.(type) {
+		case nil:
+			assert.Equal(t, testCase["exp_rp"], "", filter)
+		case legacyValue:
+			assert.Equal(t, testCase["exp_rp"], rp.text, filter)
+		case literalExpr:
+			assert.Equal(t, testCase["exp_rp"], fmt.Sprint(rp.value), filter)
+		default:
+			t.Errorf("%s: unexpected operand %#v", filter, rp)
+		}
 	}
 }
 
@@ -855,17 +1211,47 @@ var testCaseFilterGetFromExplicitPath = []map[string]interface{}{
 		"query":    "$.a[0]",
 		"expected": "b",
 	},
+	{
+		// 6 {'a': ['b',1]}
+		"obj":      map[string]interface{}{"a": []interface{}{"b", 1}},
+		"query":    "$.a[-1:]",
+		"expected": 1,
+	},
+	{
+		// 7 {"a": {"b": {"c": 1}}}
+		"obj":      map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}}},
+		"query":    "$..c",
+		"expected": 1,
+	},
+	{
+		// 8 {"a": [{"b": 1}, {"b": 2}]}
+		"obj":      map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": 1}, map[string]interface{}{"b": 2}}},
+		"query":    "$.a[?(@.b > 1)].b",
+		"expected": 2,
+	},
+	{
+		// 9 {"a": 1}
+		"obj":      map[string]interface{}{"a": 1},
+		"query":    "$.b[0]",
+		"expected": nil,
+	},
 }
 
 func Test_jsonpath_filter_get_from_explicit_path(t *testing.T) {
 	for _, testCase := range testCaseFilterGetFromExplicitPath {
 		obj := testCase["obj"]
 		query := testCase["query"].(string)
-		expected := testCase["expected"]
-
-		res, err := filterGetFromExplicitPath(obj, query)
-		assert.Nil(t, err)
-		assert.Equal(t, res, expected)
+		res, err := Lookup(obj, query)
+		assert.Nil(t, err, query)
+		if testCase["expected"] == nil {
+			assert.Empty(t, res, query)
+			continue
+		}
+		values := make([]interface{}, 0, len(res))
+		for _, v := range res {
+			values = append(values, v)
+		}
+		assert.Equal(t, []interface{}{testCase["expected"]}, values, query)
 	}
 }
 
@@ -936,20 +1322,22 @@ var testCaseEvalFilter = []map[string]interface{}{
 	},
 }
 
+// TestJsonpathEvalFilter 把obj放到root的items中, 检查filter是否选中它
 func TestJsonpathEvalFilter(t *testing.T) {
-	for idx, tcase := range testCaseEvalFilter[1:] {
-		fmt.Println("------------------------------")
-		obj := tcase["obj"].(map[string]interface{})
-		root := tcase["root"].(map[string]interface{})
-		lp := tcase["lp"].(string)
-		op := tcase["op"].(string)
-		rp := tcase["rp"].(string)
-		exp := tcase["exp"].(bool)
-		t.Logf("idx: %v, lp: %v, op: %v, rp: %v, exp: %v", idx, lp, op, rp, exp)
-		got, err := evalFilter(obj, root, lp, op, rp)
-		assert.Nil(t, err)
-		assert.Equal(t, got, exp)
-
+	for idx, tcase := range testCaseEvalFilter {
+		root := make(map[string]interface{})
+		for k, v := range tcase["root"].(map[string]interface{}) {
+			root[k] = v
+		}
+		root["items"] = []interface{}{tcase["obj"]}
+		filter := tcase["lp"].(string)
+		if op := tcase["op"].(string); op != "exists" {
+			filter += " " + op + " " + tcase["rp"].(string)
+		}
+		t.Logf("idx: %v, filter: %v, exp: %v", idx, filter, tcase["exp"])
+		res, err := Lookup(root, "$.items[?("+filter+")]")
+		assert.Nil(t, err, filter)
+		assert.Equal(t, tcase["exp"], len(res) == 1, filter)
 	}
 }
 
@@ -998,7 +1386,7 @@ var testCaseCmpAny = []map[string]interface{}{
 		"obj2": 2,
 		"op":   "=~",
 		"exp":  false,
-		"err":  errors.New("op should only be <, <=, ==, >= and >"),
+		"err":  errors.New("op should only be <, <=, ==, !=, >=, >, in, nin, subsetof, anyof, noneof, contains, size and empty"),
 	},
 	{
 		"obj1": ifc1,
@@ -1021,60 +1409,214 @@ var testCaseCmpAny = []map[string]interface{}{
 		"exp":  false,
 		"err":  nil,
 	},
-}
-
-func TestJsonpathCmpAny(t *testing.T) {
-	for idx, testCase := range testCaseCmpAny {
-		t.Logf("idx: %v, %v %v %v, exp: %v", idx, testCase["obj1"], testCase["op"], testCase["obj2"], testCase["exp"])
-		res, err := cmpAny(testCase["obj1"], testCase["obj2"], testCase["op"].(string))
-		exp := testCase["exp"].(bool)
-		expErr := testCase["err"]
-		assert.Equal(t, err, expErr)
-		assert.Equal(t, res, exp)
-	}
-}
-
-func TestJsonpathNullInTheMiddle(t *testing.T) {
-	data := `{
-		"head_commit": null,
-		"test": {
-			"author": {
-				"username": "Jack"
-			}
-		}
-	}
-	`
-	type (
-		caseType struct {
-			data     string
-			jsonPath string
-			expRes   map[string]interface{}
-		}
-	)
-	cases := []caseType{
-		{
-			data:     data,
-			jsonPath: "$.test[*]",
-			expRes: map[string]interface{}{
-				"$.test.author": map[string]interface{}{
-					"username": "Jack",
-				},
-			},
-		},
-		{
-			data:     data,
-			jsonPath: "$..author.username",
-			expRes: map[string]interface{}{
-				"$.test.author.username": "Jack",
-			},
-		},
-	}
-
-	var (
-		j   interface{}
-		err error
-	)
-	err = json.Unmarshal([]byte(data), &j)
+	{
+		"obj1": json.Number("1.0"),
+		"obj2": "1",
+		"op":   "!=",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": json.Number("1.0"),
+		"obj2": 1,
+		"op":   "==",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": json.Number("8.95"),
+		"obj2": 8.95,
+		"op":   "==",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": "007",
+		"obj2": 7.0,
+		"op":   "==",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": nil,
+		"obj2": false,
+		"op":   "==",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": true,
+		"obj2": false,
+		"op":   "<",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": `a"b`,
+		"obj2": `a"c`,
+		"op":   "<",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": json.Number("9007199254740993"),
+		"obj2": json.Number("9007199254740992"),
+		"op":   ">",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": 2,
+		"obj2": []interface{}{"1", "2"},
+		"op":   "in",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": 2,
+		"obj2": []interface{}{1.0, 2.0},
+		"op":   "in",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": "c",
+		"obj2": []string{"a", "b"},
+		"op":   "nin",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": []interface{}{"a", "b"},
+		"obj2": []interface{}{"a", "b", "c"},
+		"op":   "subsetof",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": []interface{}{"a", "d"},
+		"obj2": []interface{}{"a", "b", "c"},
+		"op":   "anyof",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": []interface{}{"a", "d"},
+		"obj2": []interface{}{"a", "b", "c"},
+		"op":   "noneof",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": "Nigel Rees",
+		"obj2": "Rees",
+		"op":   "contains",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": []interface{}{1, 2},
+		"obj2": "2",
+		"op":   "contains",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": []interface{}{1, 2},
+		"obj2": json.Number("2"),
+		"op":   "contains",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": "汉字",
+		"obj2": json.Number("2"),
+		"op":   "size",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": "汉字",
+		"obj2": "2",
+		"op":   "size",
+		"exp":  false,
+		"err":  nil,
+	},
+	{
+		"obj1": map[string]interface{}{},
+		"obj2": true,
+		"op":   "empty",
+		"exp":  true,
+		"err":  nil,
+	},
+	{
+		"obj1": map[string]interface{}{},
+		"obj2": "true",
+		"op":   "empty",
+		"exp":  false,
+		"err":  errors.New("right side of empty should be true or false"),
+	},
+	{
+		"obj1": 1,
+		"obj2": true,
+		"op":   "empty",
+		"exp":  false,
+		"err":  nil,
+	},
+}
+
+func TestJsonpathCmpAny(t *testing.T) {
+	for idx, testCase := range testCaseCmpAny {
+		t.Logf("idx: %v, %v %v %v, exp: %v", idx, testCase["obj1"], testCase["op"], testCase["obj2"], testCase["exp"])
+		res, err := cmpAny(testCase["obj1"], testCase["obj2"], testCase["op"].(string))
+		exp := testCase["exp"].(bool)
+		expErr := testCase["err"]
+		assert.Equal(t, err, expErr)
+		assert.Equal(t, res, exp)
+	}
+}
+
+func TestJsonpathNullInTheMiddle(t *testing.T) {
+	data := `{
+		"head_commit": null,
+		"test": {
+			"author": {
+				"username": "Jack"
+			}
+		}
+	}
+	`
+	type (
+		caseType struct {
+			data     string
+			jsonPath string
+			expRes   map[string]interface{}
+		}
+	)
+	cases := []caseType{
+		{
+			data:     data,
+			jsonPath: "$.test[*]",
+			expRes: map[string]interface{}{
+				"$.test.author": map[string]interface{}{
+					"username": "Jack",
+				},
+			},
+		},
+		{
+			data:     data,
+			jsonPath: "$..author.username",
+			expRes: map[string]interface{}{
+				"$.test.author.username": "Jack",
+			},
+		},
+	}
+
+	var (
+		j   interface{}
+		err error
+	)
+	err = json.Unmarshal([]byte(data), &j)
 	assert.Nil(t, err)
 	for _, oneCase := range cases {
 		err = json.Unmarshal([]byte(oneCase.data), &j)
@@ -1125,6 +1667,8 @@ var tcases_reg_op = []struct {
 	{`"/xxx/"`, ``, true},
 	{`/xxx/`, `xxx`, false},
 	{`/π/`, `π`, false},
+	{`/xxx/i`, `(?i)xxx`, false},
+	{`/xxx/x`, ``, true},
 }
 
 func TestRegOp(t *testing.T) {
@@ -1183,7 +1727,7 @@ func TestJsonpathRootnodeIsArrayRange(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	res, err := Lookup(j, "$[:1].test")
+	res, err := Lookup(j, "$[:2].test")
 	assert.Nil(t, err)
 	assert.Equal(t, res, map[string]interface{}{
 		"$[0].test": 12.34,
@@ -1218,7 +1762,7 @@ func TestJsonpathRootnodeIsNestedArrayRange(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	res, err := Lookup(j, "$[:1].[0].test")
+	res, err := Lookup(j, "$[:2].[0].test")
 	assert.Nil(t, err)
 	assert.Equal(t, res, map[string]interface{}{
 		"$[0][0].test": 1.1,
@@ -1271,6 +1815,30 @@ func getValue(obj interface{}, path string) interface{} {
 
 func TestDeleteBody(t *testing.T) {
 
+	t.Run("negative indexes", func(t *testing.T) {
+		// 负数下标都相对删除前的数组
+		tcases := []struct {
+			paths []string
+			want  string
+		}{
+			{paths: []string{"$.a[-1]", "$.a[-2]"}, want: `{"a":[1,2]}`},
+			{paths: []string{"$.a[-2]", "$.a[-1]"}, want: `{"a":[1,2]}`},
+			{paths: []string{"$.a[0]", "$.a[-1]", "$.a[-3]"}, want: `{"a":[3]}`},
+			{paths: []string{"$.a[-4]", "$.a[3]", "$.a[-4]"}, want: `{"a":[2,3]}`},
+			{paths: []string{"$.b[-1][-1]", "$.b[-1][0]", "$.b[-2]"}, want: `{"a":[1,2,3,4],"b":[[7]]}`},
+		}
+		for _, tcase := range tcases {
+			var body interface{}
+			_ = json.Unmarshal([]byte(`{"a":[1,2,3,4],"b":[[5],[6,7,8]]}`), &body)
+			if strings.HasPrefix(tcase.paths[0], "$.a") {
+				delete(body.(map[string]interface{}), "b")
+			}
+			assert.Nil(t, DeleteBody(body, tcase.paths), tcase.paths)
+			out, _ := json.Marshal(body)
+			assert.Equal(t, tcase.want, string(out), tcase.paths)
+		}
+	})
+
 	t.Run("deleteByKey", func(t *testing.T) {
 		tcases := []string{
 			"$.store.book[*].price",
@@ -1430,3 +1998,1255 @@ func TestParseJsonTemplate(t *testing.T) {
 		"e1_":   {"$.extra[0].e1", "$.extra[0].e2"},
 	}, res)
 }
+
+// jsonBody 返回一个每次都重新解析data的函数, 修改body的用例用它取得互不影响的副本
+func jsonBody(t *testing.T, data string) func() interface{} {
+	return func() interface{} {
+		var body interface{}
+		assert.Nil(t, json.Unmarshal([]byte(data), &body))
+		return body
+	}
+}
+
+func Test_jsonpath_quoted_member_name(t *testing.T) {
+	newBody := jsonBody(t, `{
+    "headers": {"content-type": "json", "a.b": 1, "user name": "bob", "x[1]": [1, 2], "it's": true},
+    "list": [{"a.b": 1}, {"a.b": 2}]
+}`)
+
+	t.Run("lookup", func(t *testing.T) {
+		body := newBody()
+		res, err := Lookup(body, `$.headers['a.b']`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"$.headers['a.b']": float64(1)}, res)
+
+		res, err = Lookup(body, `$["headers"]["user name"]`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"$.headers.user name": "bob"}, res)
+
+		res, err = Lookup(body, `$.headers['x[1]'][1]`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"$.headers['x[1]'][1]": float64(2)}, res)
+
+		res, err = Lookup(body, `$.headers['it\'s']`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{`$.headers['it\'s']`: true}, res)
+
+		res, err = Lookup(body, `$.list[*]['a.b']`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"$.list[0]['a.b']": float64(1), "$.list[1]['a.b']": float64(2)}, res)
+
+		// 返回的key可以直接再用来查找
+		for k, v := range res {
+			again, err := Lookup(body, k)
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]interface{}{k: v}, again)
+		}
+
+		_, err = Lookup(body, `$.headers['a.b`)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		body := newBody()
+		assert.Nil(t, SetToBody(body, `$.headers['content-type']`, "xml"))
+		assert.Nil(t, SetToBody(body, `$["headers"]['a.b']`, 2))
+		assert.Nil(t, SetToBody(body, `$.list[-1]['a.b']`, 3))
+		headers := body.(map[string]interface{})["headers"].(map[string]interface{})
+		assert.Equal(t, "xml", headers["content-type"])
+		assert.Equal(t, 2, headers["a.b"])
+		assert.Equal(t, 3, body.(map[string]interface{})["list"].([]interface{})[1].(map[string]interface{})["a.b"])
+
+		assert.NotNil(t, SetToBody(body, `$.headers['a.b'`, 1))
+		assert.NotNil(t, SetToBody(body, `$.list[*]['a.b']`, 1))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		body := newBody()
+		assert.Nil(t, DeleteBody(body, []string{`$.headers['a.b']`, `$.headers["x[1]"][0]`}))
+		headers := body.(map[string]interface{})["headers"].(map[string]interface{})
+		_, ok := headers["a.b"]
+		assert.False(t, ok)
+		assert.Equal(t, []interface{}{float64(2)}, headers["x[1]"])
+
+		assert.Nil(t, DeleteByKey(body, `$.list[*]['a.b']`))
+		assert.Equal(t, []interface{}{map[string]interface{}{}, map[string]interface{}{}}, body.(map[string]interface{})["list"])
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		body := newBody()
+		err := Rename(body, RenamesConfig{Config: []RenameConfig{
+			{From: `$.headers['a.b']`, To: `$.headers['a-b']`},
+			{From: `$['list'][*]['a.b']`, To: `$['new.list'][*].ab`},
+		}})
+		assert.Nil(t, err)
+		m := body.(map[string]interface{})
+		headers := m["headers"].(map[string]interface{})
+		assert.Equal(t, float64(1), headers["a-b"])
+		_, ok := headers["a.b"]
+		assert.False(t, ok)
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"ab": float64(1)},
+			map[string]interface{}{"ab": float64(2)},
+		}, m["new.list"])
+		_, ok = m["list"]
+		assert.False(t, ok)
+	})
+}
+
+func Test_jsonpath_compile(t *testing.T) {
+	c, err := Compile("$.store.book[?(@.price < $.expensive)].title")
+	assert.Nil(t, err)
+	assert.Equal(t, "compiled lookup: $.store.book[?(@.price < $.expensive)].title", c.String())
+
+	exp := map[string]interface{}{
+		"$.store.book[0].title": "Sayings of the Century",
+		"$.store.book[2].title": "Moby Dick",
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				res, err := c.Lookup(jsonData)
+				assert.Nil(t, err)
+				assert.Equal(t, exp, res)
+				res, err = c.Lookup(jsonDataV2)
+				assert.Nil(t, err)
+				assert.Empty(t, res)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err = Compile("$.store.book[")
+	assert.NotNil(t, err)
+	assert.NotPanics(t, func() { MustCompile("$..price") })
+	assert.Panics(t, func() { MustCompile("$.store.book[") })
+}
+
+func Test_jsonpath_query(t *testing.T) {
+	res, err := Query(jsonData, "$.store.book[-1:1:-1].author")
+	assert.Nil(t, err)
+	books := jsonData.(map[string]interface{})["store"].(map[string]interface{})["book"].([]interface{})
+	assert.Equal(t, []Match{
+		{Path: "$['store']['book'][3]['author']", Value: "J. R. R. Tolkien", Parent: books[3], Key: "author"},
+		{Path: "$['store']['book'][2]['author']", Value: "Herman Melville", Parent: books[2], Key: "author"},
+	}, res)
+
+	res, err = Query(jsonData, "$.store.book[-1]")
+	assert.Nil(t, err)
+	assert.Equal(t, []Match{{Path: "$['store']['book'][3]", Value: books[3], Parent: books, Key: 3}}, res)
+
+	res, err = Query(jsonData, "$")
+	assert.Nil(t, err)
+	assert.Equal(t, []Match{{Path: "$", Value: jsonData}}, res)
+
+	// object的key按字典序遍历
+	res, err = Query(jsonData, "$.store.bicycle.*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$['store']['bicycle']['color']", "$['store']['bicycle']['price']"}, []string{res[0].Path, res[1].Path})
+
+	// ModeLegacy和Lookup一样去掉重复的节点, ModeRFC9535保留
+	res, err = Query(jsonData, "$.store.book[0,-4,0].price")
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	res, err = QueryWithOptions(jsonData, "$.store.book[0,-4,0].price", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	assert.Len(t, res, 3)
+
+	c := MustCompile("$..price")
+	for i := 0; i < 10; i++ {
+		res, err = c.Select(jsonData)
+		assert.Nil(t, err)
+		paths := make([]string, 0, len(res))
+		for _, m := range res {
+			paths = append(paths, m.Path)
+		}
+		assert.Equal(t, []string{
+			"$['store']['bicycle']['price']",
+			"$['store']['book'][0]['price']",
+			"$['store']['book'][1]['price']",
+			"$['store']['book'][2]['price']",
+			"$['store']['book'][3]['price']",
+		}, paths)
+	}
+
+	_, err = Query(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+}
+
+func Test_jsonpath_normalized_paths(t *testing.T) {
+	newBody := jsonBody(t, `{"a.b": {"c[0]": [1, {"it's": 2, "x\u0001\n": 3}]}, "k": [[1, 2]], "n": null}`)
+
+	res, err := LookupWithOptions(newBody(), "$['a.b']..*", Options{NormalizedPaths: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		`$['a.b']['c[0]']`:                 []interface{}{float64(1), map[string]interface{}{"it's": float64(2), "x\u0001\n": float64(3)}},
+		`$['a.b']['c[0]'][0]`:              float64(1),
+		`$['a.b']['c[0]'][1]`:              map[string]interface{}{"it's": float64(2), "x\u0001\n": float64(3)},
+		`$['a.b']['c[0]'][1]['it\'s']`:     float64(2),
+		`$['a.b']['c[0]'][1]['x\u0001\n']`: float64(3),
+	}, res)
+
+	// Normalized Path可以直接用于SetToBody和DeleteBody
+	res, err = LookupWithOptions(newBody(), "$..*", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	for path, value := range res {
+		body := newBody()
+		assert.Nil(t, SetToBody(body, path, "new"), path)
+		got, err := LookupWithOptions(body, path, Options{Mode: ModeRFC9535})
+		assert.Nil(t, err, path)
+		assert.Equal(t, map[string]interface{}{path: "new"}, got, path)
+
+		body = newBody()
+		assert.Nil(t, DeleteBody(body, []string{path}), path)
+		got, err = LookupWithOptions(body, "$..*", Options{Mode: ModeRFC9535})
+		assert.Nil(t, err, path)
+		if _, ok := value.(float64); ok && strings.HasSuffix(path, "]") && !strings.HasSuffix(path, "']") {
+			// 删除数组元素后后面的元素会前移
+			continue
+		}
+		_, ok := got[path]
+		assert.False(t, ok, path)
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		// 删除时不会影响原有的null, 嵌套数组中的元素也能删除
+		body := newBody()
+		assert.Nil(t, DeleteBody(body, []string{"$['k'][0][0]", `$['a.b']['c[0]'][1]['x\u0001\n']`, "$['missing']"}))
+		assert.Equal(t, map[string]interface{}{
+			"a.b": map[string]interface{}{"c[0]": []interface{}{float64(1), map[string]interface{}{"it's": float64(2)}}},
+			"k":   []interface{}{[]interface{}{float64(2)}},
+			"n":   nil,
+		}, body)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		body := newBody()
+		assert.Nil(t, Rename(body, RenamesConfig{Config: []RenameConfig{
+			{From: `$['a.b']['c[0]'][*]['it\'s']`, To: `$['a']['c[0]'][*]['its']`},
+			{From: "$['a.b']", To: "$['a']"},
+		}}))
+		assert.Equal(t, map[string]interface{}{
+			"a": map[string]interface{}{"c[0]": []interface{}{float64(1), map[string]interface{}{"its": float64(2), "x\u0001\n": float64(3)}}},
+			"k": []interface{}{[]interface{}{float64(1), float64(2)}},
+			"n": nil,
+		}, body)
+	})
+}
+
+func Test_jsonpath_getter(t *testing.T) {
+	s, err := GetString(jsonData, "$.store.book[0].author")
+	assert.Nil(t, err)
+	assert.Equal(t, "Nigel Rees", s)
+	i, err := GetInt64(jsonData, "$.expensive")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), i)
+	f, err := GetFloat64(jsonData, "$.store.bicycle.price")
+	assert.Nil(t, err)
+	assert.Equal(t, 19.95, f)
+	l, err := GetSlice(jsonData, "$.store.book")
+	assert.Nil(t, err)
+	assert.Len(t, l, 4)
+	m, err := GetMap(jsonData, "$.store.bicycle")
+	assert.Nil(t, err)
+	assert.Equal(t, "red", m["color"])
+
+	var body interface{}
+	_ = json.Unmarshal([]byte(`{"ok": true, "n": 3.0, "big": 1e20, "s": "3"}`), &body)
+	b, err := GetBool(body, "$.ok")
+	assert.Nil(t, err)
+	assert.True(t, b)
+	i, err = GetInt64(body, "$.n")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), i)
+
+	// 不能转换时错误中包含值的路径
+	_, err = GetInt64(jsonData, "$.store.bicycle.price")
+	assert.EqualError(t, err, "$['store']['bicycle']['price'] is number, not int64")
+	_, err = GetInt64(body, "$.big")
+	assert.NotNil(t, err)
+	_, err = GetInt64(body, "$.s")
+	assert.EqualError(t, err, "$['s'] is string, not int64")
+	_, err = GetString(jsonData, "$.expensive")
+	assert.NotNil(t, err)
+	_, err = GetBool(body, "$.missing")
+	assert.EqualError(t, err, "$.missing not found")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = GetFloat64(jsonData, "$..price")
+	assert.EqualError(t, err, "$..price should select exactly one value, got 5")
+	assert.ErrorIs(t, err, ErrMultipleMatches)
+	var me *MultipleMatchesError
+	assert.True(t, errors.As(err, &me))
+	assert.Equal(t, 5, me.Count)
+	_, err = GetString(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+}
+
+func Test_jsonpath_lookup_as(t *testing.T) {
+	prices, err := LookupAs[float64](jsonData, "$.store.book[*].price")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"$.store.book[0].price": 8.95,
+		"$.store.book[1].price": 12.99,
+		"$.store.book[2].price": 8.99,
+		"$.store.book[3].price": 22.99,
+	}, prices)
+
+	var body interface{}
+	_ = json.Unmarshal([]byte(`{"a": [1, 2.0, 255, 256, -1, null], "s": ["x", "y"]}`), &body)
+	ints, err := LookupAs[int](body, "$.a[0:2]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"$.a[0]": 1, "$.a[1]": 2}, ints)
+	bytes, err := LookupAs[uint8](body, "$.a[2]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]uint8{"$.a[2]": 255}, bytes)
+	_, err = LookupAs[uint8](body, "$.a[3]")
+	assert.EqualError(t, err, "$.a[3] is number, not uint8")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	_, err = LookupAs[uint](body, "$.a[4]")
+	assert.NotNil(t, err)
+	nums, err := LookupAs[json.Number](body, "$.a[0,4]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]json.Number{"$.a[0]": "1", "$.a[4]": "-1"}, nums)
+	values, err := LookupAs[interface{}](body, "$.a[5]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.a[5]": nil}, values)
+	_, err = LookupAs[int](body, "$.a[5]")
+	assert.NotNil(t, err)
+
+	type name string
+	names, err := LookupAs[name](body, "$.s[*]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]name{"$.s[0]": "x", "$.s[1]": "y"}, names)
+	_, err = LookupAs[bool](body, "$.s[*]")
+	assert.NotNil(t, err)
+	_, err = LookupAs[string](jsonData, "$.expensive")
+	assert.EqualError(t, err, "$.expensive is number, not string")
+}
+
+func Test_jsonpath_exists_count_first(t *testing.T) {
+	ok, err := Exists(jsonData, "$.store.book[?(@.isbn)]")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = Exists(jsonData, "$.store.book[?(@.price > 100)]")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	n, err := Count(jsonData, "$..price")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	// 和Lookup、Select一样, ModeLegacy下重复的节点只算一次
+	n, err = Count(jsonData, "$.store.book[0,-4,0:1]")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = MustCompile("$.store.book[0,-4,0:1]").Count(jsonData)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	c, err := CompileWithOptions("$.store.book[0,-4,0:1]", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	n, err = c.Count(jsonData)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	m, ok, err := First(jsonData, "$.store.book[?(@.price < 10)].title")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "$['store']['book'][0]['title']", m.Path)
+	assert.Equal(t, "Sayings of the Century", m.Value)
+	_, ok, err = First(jsonData, "$.store.book[?(@.price > 100)].title")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 选中第一个值后就停止遍历, 后面会出错的元素不会被求值
+	var body interface{}
+	_ = json.Unmarshal([]byte(`{"a": [{"x": "abc"}, {"x": 1}]}`), &body)
+	_, err = Lookup(body, "$.a[?(@.x =~ /b/)]")
+	assert.NotNil(t, err)
+	ok, err = Exists(body, "$.a[?(@.x =~ /b/)]")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	m, ok, err = First(body, "$.a[?(@.x =~ /b/)]")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 0, m.Key)
+	_, err = Count(body, "$.a[?(@.x =~ /b/)]")
+	assert.NotNil(t, err)
+	_, err = Exists(body, "$.a[?(@.x =~ /z/)]")
+	assert.NotNil(t, err)
+
+	_, err = Exists(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+	_, err = Count(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+	_, _, err = First(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+}
+
+func Test_jsonpath_parse(t *testing.T) {
+	tcases := []struct {
+		path      string
+		mode      Mode
+		canonical string
+	}{
+		{path: "$", canonical: "$"},
+		{path: "$.store.book[0].title", canonical: "$.store.book[0].title"},
+		{path: `$["store"]['book'][-1]`, canonical: "$.store.book[-1]"},
+		{path: "$.headers['content-type']['a.b']", canonical: "$.headers['content-type']['a.b']"},
+		{path: "$.headers.content-type", canonical: "$.headers['content-type']"},
+		{path: "$[*].a[ * ]", canonical: "$.*.a.*"},
+		{path: "$..book[:2]..price", canonical: "$..book[:2]..price"},
+		{path: "$..*", canonical: "$..*"},
+		{path: "$..['a b',0,1:3:2,::-1]", canonical: "$..['a b',0,1:3:2,::-1]"},
+		{path: "$.book[?(@.isbn)]", canonical: "$.book[?(@.isbn)]"},
+		{path: "$.book[?(@.price<10&&@.category=='fiction'||!@.isbn)]", canonical: "$.book[?(@.price < 10 && @.category == 'fiction' || !@.isbn)]"},
+		{path: "$.book[?((@.a || @.b) && !(@.c == 1))]", canonical: "$.book[?((@.a || @.b) && !(@.c == 1))]"},
+		{path: "$.book[?(@.author =~ /tolkien/i)]", canonical: "$.book[?(@.author =~ /tolkien/i)]"},
+		{path: "$.book[?(@.category == fiction)]", canonical: "$.book[?(@.category == 'fiction')]"},
+		{path: `$.book[?(@.status in ["open", 1, true, null])]`, canonical: "$.book[?(@.status in ['open', 1, true, null])]"},
+		{path: "$.book[?(length(@.title) > 20 && @.price <= $.expensive)]", canonical: "$.book[?(length(@.title) > 20 && @.price <= $.expensive)]"},
+		{path: "$.book[?@.price < 10 && match(@.isbn, 'it\\'s')]", mode: ModeRFC9535, canonical: "$.book[?(@.price < 10 && match(@.isbn, 'it\\'s'))]"},
+		{path: "$['a','b']", mode: ModeRFC9535, canonical: "$['a','b']"},
+		{path: "$[?count(@.*) == 1e2]", mode: ModeRFC9535, canonical: "$[?(count(@.*) == 1e2)]"},
+	}
+	for _, tcase := range tcases {
+		p, err := ParseWithOptions(tcase.path, Options{Mode: tcase.mode})
+		if !assert.Nil(t, err, tcase.path) {
+			continue
+		}
+		assert.Equal(t, tcase.canonical, p.String(), tcase.path)
+		// 规范写法再解析一次, 得到同样的语法树
+		again, err := ParseWithOptions(p.String(), Options{Mode: tcase.mode})
+		assert.Nil(t, err, tcase.path)
+		assert.Equal(t, p, again, tcase.path)
+	}
+
+	p, err := Parse("$.store.book[?(@.price < $.expensive)].title")
+	assert.Nil(t, err)
+	assert.Len(t, p.Segments, 4)
+	assert.Equal(t, Selector{Kind: NameSelector, Name: "store"}, p.Segments[0].Selectors[0])
+	assert.Equal(t, FilterSelector, p.Segments[2].Selectors[0].Kind)
+	cmp := p.Segments[2].Selectors[0].Filter.(*ComparisonExpr)
+	assert.Equal(t, "<", cmp.Op)
+	assert.Equal(t, &Path{Relative: true, Segments: []Segment{{Selectors: []Selector{{Kind: NameSelector, Name: "price"}}}}}, cmp.Left)
+	assert.Equal(t, "$.expensive", cmp.Right.String())
+
+	p, err = Parse("$.a[1:]")
+	assert.Nil(t, err)
+	assert.Equal(t, Slice{Start: 1, Step: 1, HasStart: true}, p.Segments[1].Selectors[0].Slice)
+
+	_, err = Parse("$.store.book[")
+	assert.NotNil(t, err)
+	_, err = ParseWithOptions("$.a.b-c", Options{Mode: ModeRFC9535})
+	assert.NotNil(t, err)
+}
+
+func Test_jsonpath_errors(t *testing.T) {
+	_, err := Lookup(jsonData, "$.store.book[?(@.price <)]")
+	assert.ErrorIs(t, err, ErrSyntax)
+	var syntaxErr *SyntaxError
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, "$.store.book[?(@.price <)]", syntaxErr.Query)
+		assert.Equal(t, 24, syntaxErr.Offset)
+		assert.Equal(t, ")", syntaxErr.Token)
+	}
+	assert.EqualError(t, err, `syntax error in "$.store.book[?(@.price <)]" at 24 near ")": unexpected character ')'`)
+	_, err = Compile("$.store.book[")
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, 13, syntaxErr.Offset)
+	assert.Equal(t, "", syntaxErr.Token)
+	assert.EqualError(t, err, `syntax error in "$.store.book[" at 13: missing selector`)
+	_, err = Parse("$.book[?(@.author =~ /a(/)]")
+	assert.ErrorIs(t, err, ErrSyntax)
+	_, err = Lookup(jsonData, "$.store.book[?(@.tags empty yes)]")
+	assert.ErrorIs(t, err, ErrSyntax)
+
+	_, err = Lookup(jsonData, "$.store.book[9].price")
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+	assert.EqualError(t, err, "index out of range: len: 4, idx: 9, path: $['store']['book']")
+	var indexErr *IndexError
+	if assert.ErrorAs(t, err, &indexErr) {
+		assert.Equal(t, IndexError{Path: "$['store']['book']", Index: 9, Length: 4}, *indexErr)
+	}
+
+	_, err = Lookup(jsonData, "$.store.bicycle[0]")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	assert.EqualError(t, err, "$['store']['bicycle'] is object, not array")
+	_, err = Lookup(jsonData, "$.expensive[1:2]")
+	assert.EqualError(t, err, "$['expensive'] is number, not array")
+
+	// filter中出错时, 路径指向出错的值
+	var body interface{}
+	_ = json.Unmarshal([]byte(`{"a": [{"x": "abc"}, {"x": 1}], "b": [{"c": [{"y": true}]}]}`), &body)
+	_, err = Lookup(body, "$.a[?(@.x =~ /b/)]")
+	assert.EqualError(t, err, "$['a'][1].x is number, not string")
+	_, err = Lookup(body, "$.b[?(@.c[?(@.y =~ /b/)])]")
+	assert.EqualError(t, err, "$['b'][0]['c'][0].y is boolean, not string")
+	_, err = Lookup(body, "$.a[?($.b =~ /b/)]")
+	assert.EqualError(t, err, "$.b is array, not string")
+
+	_, err = GetString(body, "$.missing")
+	var notFound *NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "$.missing", notFound.Path)
+
+	err = SetToBody(body, "$.a[5].x", 1)
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+	assert.EqualError(t, err, "index out of range: len: 2, idx: 5, path: $['a']")
+	err = SetToBody(body, "$.a[*].x", 1)
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	err = SetToBody(body, "$.a[", 1)
+	assert.ErrorIs(t, err, ErrSyntax)
+	err = DeleteBody(body, []string{"$.a.."})
+	assert.ErrorIs(t, err, ErrSyntax)
+}
+
+func Test_jsonpath_policy(t *testing.T) {
+	newBody := jsonBody(t, `{"a": {"b": [1, 2]}, "n": null, "s": "str"}`)
+
+	t.Run("lookup", func(t *testing.T) {
+		tcases := []struct {
+			path    string
+			policy  Policy
+			mode    Mode
+			res     int
+			errKind error
+		}{
+			{path: "$.a.x", policy: PolicyDefault, res: 0},
+			{path: "$.a.x", policy: PolicyStrict, errKind: ErrNotFound},
+			{path: "$.a.x", policy: PolicyLenient, res: 0},
+			{path: "$.a.x", policy: PolicyRFC9535, res: 0},
+			{path: "$.a.b[5]", policy: PolicyDefault, errKind: ErrIndexOutOfRange},
+			{path: "$.a.b[5]", policy: PolicyStrict, errKind: ErrIndexOutOfRange},
+			{path: "$.a.b[5]", policy: PolicyLenient, res: 0},
+			{path: "$.a.b[5]", policy: PolicyRFC9535, res: 0},
+			{path: "$.a.b[5]", policy: PolicyDefault, mode: ModeRFC9535, res: 0},
+			{path: "$.a.b[5]", policy: PolicyStrict, mode: ModeRFC9535, errKind: ErrIndexOutOfRange},
+			{path: "$.s[0]", policy: PolicyDefault, errKind: ErrTypeMismatch},
+			{path: "$.s[0]", policy: PolicyLenient, res: 0},
+			{path: "$.s.x", policy: PolicyDefault, res: 0},
+			{path: "$.s.x", policy: PolicyStrict, errKind: ErrTypeMismatch},
+			{path: "$.n[0]", policy: PolicyDefault, res: 0},
+			{path: "$.n[0]", policy: PolicyStrict, errKind: ErrTypeMismatch},
+			// ..、多选择器和filter中选不中的不算错误
+			{path: "$..x", policy: PolicyStrict, res: 0},
+			{path: "$.a.b[0,5]", policy: PolicyStrict, res: 1},
+			{path: "$.a[?(@.x[3])]", policy: PolicyStrict, res: 0},
+			{path: "$.a.b[*]", policy: PolicyStrict, res: 2},
+		}
+		for _, tcase := range tcases {
+			res, err := LookupWithOptions(newBody(), tcase.path, Options{Mode: tcase.mode, Policy: tcase.policy})
+			if tcase.errKind != nil {
+				assert.ErrorIs(t, err, tcase.errKind, "%s %v", tcase.path, tcase.policy)
+				continue
+			}
+			assert.Nil(t, err, "%s %v", tcase.path, tcase.policy)
+			assert.Len(t, res, tcase.res, "%s %v", tcase.path, tcase.policy)
+		}
+		_, err := LookupWithOptions(newBody(), "$.a.x.y", Options{Policy: PolicyStrict})
+		assert.EqualError(t, err, "$['a']['x'] not found")
+	})
+
+	t.Run("set", func(t *testing.T) {
+		tcases := []struct {
+			path    string
+			policy  Policy
+			errKind error
+			changed bool
+		}{
+			{path: "$.a.c", policy: PolicyDefault, changed: true},
+			{path: "$.a.c", policy: PolicyStrict, changed: true},
+			{path: "$.x.c", policy: PolicyDefault},
+			{path: "$.x.c", policy: PolicyStrict, errKind: ErrNotFound},
+			{path: "$.x.c", policy: PolicyLenient},
+			{path: "$.x.c", policy: PolicyRFC9535, errKind: ErrNotFound},
+			{path: "$.a.b[5]", policy: PolicyDefault, errKind: ErrIndexOutOfRange},
+			{path: "$.a.b[5]", policy: PolicyLenient},
+			{path: "$.s.x", policy: PolicyDefault},
+			{path: "$.s.x", policy: PolicyStrict, errKind: ErrTypeMismatch},
+			{path: "$.n[0]", policy: PolicyStrict, errKind: ErrTypeMismatch},
+			{path: "$.n[0]", policy: PolicyLenient},
+		}
+		for _, tcase := range tcases {
+			body := newBody()
+			err := SetToBodyWithOptions(body, tcase.path, 9, Options{Policy: tcase.policy})
+			if tcase.errKind != nil {
+				assert.ErrorIs(t, err, tcase.errKind, "%s %v", tcase.path, tcase.policy)
+			} else {
+				assert.Nil(t, err, "%s %v", tcase.path, tcase.policy)
+			}
+			assert.Equal(t, tcase.changed, !assert.ObjectsAreEqual(newBody(), body), "%s %v", tcase.path, tcase.policy)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		tcases := []struct {
+			paths   []string
+			policy  Policy
+			errKind error
+		}{
+			{paths: []string{"$.a.b[0]", "$.a.x"}, policy: PolicyDefault},
+			{paths: []string{"$.a.b[0]", "$.a.x"}, policy: PolicyLenient},
+			{paths: []string{"$.a.b[0]", "$.a.x"}, policy: PolicyStrict, errKind: ErrNotFound},
+			{paths: []string{"$.a.b[0]", "$.a.b[2]"}, policy: PolicyRFC9535, errKind: ErrIndexOutOfRange},
+			{paths: []string{"$.a.b[0]", "$.s[0]"}, policy: PolicyStrict, errKind: ErrTypeMismatch},
+		}
+		for _, tcase := range tcases {
+			body := newBody()
+			err := DeleteBodyWithOptions(body, tcase.paths, Options{Policy: tcase.policy})
+			if tcase.errKind != nil {
+				assert.ErrorIs(t, err, tcase.errKind, "%v %v", tcase.paths, tcase.policy)
+				// 返回错误时body不会被修改
+				assert.Equal(t, newBody(), body)
+				continue
+			}
+			assert.Nil(t, err, "%v %v", tcase.paths, tcase.policy)
+			assert.Equal(t, []interface{}{float64(2)}, body.(map[string]interface{})["a"].(map[string]interface{})["b"])
+		}
+
+		body := newBody()
+		assert.ErrorIs(t, DeleteByKeyWithOptions(body, "$.a.x", Options{Policy: PolicyStrict}), ErrNotFound)
+		assert.Nil(t, DeleteByKeyWithOptions(body, "$.a.b[5]", Options{Policy: PolicyLenient}))
+		assert.Equal(t, newBody(), body)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		config := RenamesConfig{Config: []RenameConfig{{From: "$.x.y", To: "$.x.z"}}}
+		body := newBody()
+		assert.Nil(t, Rename(body, config))
+		assert.Equal(t, newBody(), body)
+		assert.ErrorIs(t, RenameWithOptions(body, config, Options{Policy: PolicyStrict}), ErrNotFound)
+		err := RenameWithOptions(body, config, Options{Policy: PolicyRFC9535})
+		var nf *NotFoundError
+		assert.True(t, errors.As(err, &nf))
+		assert.Equal(t, "$['x']", nf.Path)
+		assert.ErrorIs(t, RenameWithOptions(body, RenamesConfig{Config: []RenameConfig{{From: "$.a.x", To: "$.a.y"}}}, Options{Policy: PolicyRFC9535}), ErrNotFound)
+		assert.Nil(t, RenameWithOptions(body, config, Options{Policy: PolicyLenient}))
+		assert.Equal(t, newBody(), body)
+
+		config = RenamesConfig{Config: []RenameConfig{{From: "$.a.b", To: "$.a.c"}}}
+		assert.Nil(t, RenameWithOptions(body, config, Options{Policy: PolicyStrict}))
+		assert.Equal(t, map[string]interface{}{"c": []interface{}{float64(1), float64(2)}}, body.(map[string]interface{})["a"])
+		body = newBody()
+		assert.Nil(t, RenameWithOptions(body, config, Options{Policy: PolicyRFC9535}))
+		assert.Equal(t, map[string]interface{}{"c": []interface{}{float64(1), float64(2)}}, body.(map[string]interface{})["a"])
+	})
+}
+
+func Test_jsonpath_compiled_cache(t *testing.T) {
+	defer SetCacheSize(DefaultCacheSize)
+	ResetCache()
+	assert.Equal(t, CacheStats{Capacity: DefaultCacheSize}, GetCacheStats())
+
+	_, err := Lookup(jsonData, "$.store.book[*].price")
+	assert.Nil(t, err)
+	_, err = Lookup(jsonData, "$.store.book[*].price")
+	assert.Nil(t, err)
+	_, err = LookupWithOptions(jsonData, "$.store.book[*].price", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	_, err = Lookup(jsonData, "$.store.book[")
+	assert.NotNil(t, err)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 3, Size: 2, Capacity: DefaultCacheSize}, GetCacheStats())
+
+	// 超出容量时淘汰最久没有使用的路径
+	SetCacheSize(2)
+	_, _ = Lookup(jsonData, "$.store.book[*].price")
+	_, _ = Exists(jsonData, "$.expensive")
+	_, _ = Count(jsonData, "$.store.book[*].price")
+	_, _ = Count(jsonData, "$..price")
+	stats := GetCacheStats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+	_, _ = Count(jsonData, "$.store.book[*].price")
+	_, _ = Exists(jsonData, "$.expensive")
+	assert.Equal(t, uint64(4), GetCacheStats().Hits)
+
+	// 容量为0时关闭缓存
+	SetCacheSize(0)
+	assert.Equal(t, 0, GetCacheStats().Size)
+	_, _ = Lookup(jsonData, "$..price")
+	_, _ = Lookup(jsonData, "$..price")
+	stats = GetCacheStats()
+	assert.Equal(t, uint64(4), stats.Hits)
+	assert.Equal(t, 0, stats.Size)
+
+	SetCacheSize(16)
+	ResetCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				res, err := Lookup(jsonData, fmt.Sprintf("$.store.book[%d].price", (i+j)%4))
+				assert.Nil(t, err)
+				assert.Len(t, res, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	stats = GetCacheStats()
+	assert.Equal(t, uint64(400), stats.Hits+stats.Misses)
+	assert.Equal(t, 4, stats.Size)
+}
+
+func Test_jsonpath_lookup_context(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{"a":[1,2,3],"b":{"c":{"d":{"e":1}}}}`), &doc)
+	assert.Nil(t, err)
+
+	res, err := LookupContext(context.Background(), doc, "$.a[*]", Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LookupContext(ctx, doc, "$..*", Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// 大文档在求值过程中取消
+	big := make([]interface{}, 10*checkInterval)
+	for i := range big {
+		big[i] = map[string]interface{}{"x": i}
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	n := 0
+	err = MustCompile("$..*").walkContext(ctx, big, func(node) bool {
+		if n++; n == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, n, len(big))
+
+	tests := []struct {
+		path   string
+		limits Limits
+		limit  string
+	}{
+		{"$.a[*]", Limits{MaxResults: 2}, "results"},
+		{"$..*", Limits{MaxNodes: 5}, "nodes"},
+		{"$.a[?(@ > 1)]", Limits{MaxNodes: 4}, "nodes"},
+		{"$..e", Limits{MaxDepth: 3}, "depth"},
+		{"$.b.c.d.e", Limits{MaxDepth: 3}, "depth"},
+		{"$.a[0]", Limits{MaxQueryLength: 5}, "query length"},
+	}
+	for _, tt := range tests {
+		_, err := LookupContext(context.Background(), doc, tt.path, Options{Limits: tt.limits})
+		assert.ErrorIs(t, err, ErrLimitExceeded, tt.path)
+		var le *LimitError
+		if assert.ErrorAs(t, err, &le, tt.path) {
+			assert.Equal(t, tt.limit, le.Limit, tt.path)
+		}
+	}
+
+	// 刚好达到上限时不报错
+	res, err = LookupContext(context.Background(), doc, "$.b.c.d.e", Options{Limits: Limits{MaxResults: 1, MaxDepth: 4, MaxQueryLength: 9}})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.b.c.d.e": float64(1)}, res)
+
+	// 同样的限制也作用于LookupWithOptions
+	_, err = LookupWithOptions(doc, "$..*", Options{Limits: Limits{MaxResults: 3}})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+type testStatus string
+
+type testAudit struct {
+	Created string `json:"created"`
+	Updated string `json:"updated,omitempty"`
+}
+
+type testItem struct {
+	SKU   string     `json:"sku"`
+	Qty   int        `json:"qty"`
+	Price *float64   `json:"price,omitempty"`
+	State testStatus `json:"state"`
+}
+
+type testOrder struct {
+	testAudit
+	ID       int64       `json:"id"`
+	Items    []*testItem `json:"items"`
+	Note     string      `json:"note,omitempty"`
+	Internal string      `json:"-"`
+	Extra    interface{} `json:"extra"`
+	Owner    *testOwner
+	secret   string
+}
+
+type testOwner struct {
+	Name string `json:"name"`
+}
+
+func Test_jsonpath_lookup_struct(t *testing.T) {
+	price := 9.5
+	order := testOrder{
+		testAudit: testAudit{Created: "2024-01-01"},
+		ID:        7,
+		Items: []*testItem{
+			{SKU: "a", Qty: 1, State: "new"},
+			{SKU: "b", Qty: 3, Price: &price, State: "paid"},
+			nil,
+		},
+		Internal: "x",
+		Extra:    map[string]interface{}{"k": []int{1, 2}},
+		secret:   "s",
+	}
+
+	res, err := Lookup(&order, "$.items[?(@.qty > 1)].sku")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.items[1].sku": "b"}, res)
+
+	res, err = Lookup(&order, `$.items[?(@.state == "new")].sku`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.items[0].sku": "a"}, res)
+
+	res, err = LookupWithOptions(&order, "$.items[?@.price > 9]", Options{Mode: ModeRFC9535})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$['items'][1]": order.Items[1]}, res)
+
+	// 嵌入的struct展开, json:"-"、未导出、omitempty的空值都不出现
+	res, err = Lookup(order, "$.*")
+	assert.Nil(t, err)
+	keys := make([]string, 0, len(res))
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"$.Owner", "$.created", "$.extra", "$.id", "$.items"}, keys)
+	assert.Nil(t, res["$.Owner"])
+
+	res, err = Lookup(&order, "$.extra.k[1]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.extra.k[1]": 2}, res)
+
+	n, err := Count(&order, "$..sku")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// 指针原样返回, 可以直接修改
+	m, ok, err := First(&order, "$.items[1]")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Same(t, order.Items[1], m.Value)
+
+	ok, err = Exists(&order, "$.items[?(@.state == 'paid' && @.price == 9.5)]")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = Exists(&order, "$[?(@.Internal)]")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 同名字段: 外层的字段优先, 同一层只有一个带tag的字段时用它, 和json.Marshal的结果一致
+	type inner struct {
+		A int `json:"a"`
+		B int
+	}
+	type other struct {
+		B int `json:"B"`
+		C int
+	}
+	type inner2 struct {
+		C int
+	}
+	type outer struct {
+		inner
+		*other
+		inner2
+		A string `json:"a"`
+	}
+	v := outer{inner: inner{A: 1, B: 2}, other: &other{B: 3, C: 4}, inner2: inner2{C: 5}, A: "x"}
+	res, err = Lookup(v, "$.*")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.a": "x", "$.B": 3}, res)
+
+	// 有类型的数组、map逐个元素转换
+	l, err := GetSlice(&order, "$.items")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{order.Items[0], order.Items[1], order.Items[2]}, l)
+	l, err = GetSlice(&order, "$.extra.k")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1, 2}, l)
+	mm, err := GetMap(&order, "$.items[1]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"sku": "b", "qty": 3, "price": &price, "state": testStatus("paid")}, mm)
+	mm, err = GetMap(map[string]interface{}{"h": testHeaders{"a": "b"}}, "$.h")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "b"}, mm)
+	_, err = GetMap(&order, "$.items")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+}
+
+type testHeaders map[string]string
+
+func Test_jsonpath_typed_containers(t *testing.T) {
+	body := map[string]interface{}{
+		"headers": testHeaders{"a": "1", "b": "2"},
+		"list":    []map[string]interface{}{{"x": 1}, {"x": 2}, {"x": 3}},
+		"ids":     map[int]string{2: "b", 10: "c", 1: "a"},
+		"tags":    []string{"x", "y", "z"},
+		"nested":  map[string][]int{"n": {1, 2, 3}},
+	}
+
+	res, err := Lookup(body, "$.headers.a")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.headers.a": "1"}, res)
+	res, err = Lookup(body, "$.list[?(@.x > 1)].x")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.list[1].x": 2, "$.list[2].x": 3}, res)
+	matches, err := Query(body, "$.ids.*")
+	assert.Nil(t, err)
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		paths = append(paths, m.Path)
+	}
+	assert.Equal(t, []string{"$['ids']['1']", "$['ids']['10']", "$['ids']['2']"}, paths)
+	res, err = Lookup(body, "$.ids['10']")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.ids.10": "c"}, res)
+
+	// 写入时转换成元素的类型
+	assert.Nil(t, SetToBody(body, "$.headers.c", "3"))
+	assert.Nil(t, SetToBody(body, "$.list[0].x", 9))
+	assert.Nil(t, SetToBody(body, "$.ids['2']", "B"))
+	assert.Nil(t, SetToBody(body, "$.tags[1]", "Y"))
+	assert.Nil(t, SetToBody(body, "$.nested.n[0]", json.Number("7")))
+	assert.Equal(t, testHeaders{"a": "1", "b": "2", "c": "3"}, body["headers"])
+	assert.Equal(t, 9, body["list"].([]map[string]interface{})[0]["x"])
+	assert.Equal(t, map[int]string{1: "a", 2: "B", 10: "c"}, body["ids"])
+	assert.Equal(t, []string{"x", "Y", "z"}, body["tags"])
+	assert.Equal(t, []int{7, 2, 3}, body["nested"].(map[string][]int)["n"])
+
+	err = SetToBody(body, "$.tags[0]", 1)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	err = SetToBody(body, "$.nested.n[0]", 1.5)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	err = SetToBody(body, "$.ids.a", "x")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+
+	assert.Nil(t, DeleteBody(body, []string{"$.headers.a", "$.ids['1']", "$.tags[0]", "$.tags[2]", "$.list[1]", "$.list[1]", "$.nested.n[1]"}))
+	assert.Equal(t, testHeaders{"b": "2", "c": "3"}, body["headers"])
+	assert.Equal(t, map[int]string{2: "B", 10: "c"}, body["ids"])
+	assert.Equal(t, []string{"Y"}, body["tags"])
+	assert.Equal(t, []map[string]interface{}{{"x": 9}, {"x": 3}}, body["list"])
+	assert.Equal(t, []int{7, 3}, body["nested"].(map[string][]int)["n"])
+
+	assert.Nil(t, DeleteByKey(body, "$.list[?(@.x == 3)]"))
+	assert.Equal(t, []map[string]interface{}{{"x": 9}}, body["list"])
+
+	assert.Nil(t, Rename(body, RenamesConfig{Config: []RenameConfig{{From: "$.headers.b", To: "$.headers.d"}}}))
+	assert.Equal(t, testHeaders{"c": "3", "d": "2"}, body["headers"])
+
+	// $本身是数组时, 通过指针才能删除元素
+	arr := []interface{}{1, 2, 3}
+	assert.ErrorIs(t, DeleteBody(arr, []string{"$[0]"}), ErrTypeMismatch)
+	assert.Equal(t, []interface{}{1, 2, 3}, arr)
+	assert.Nil(t, DeleteBody(&arr, []string{"$[0]"}))
+	assert.Equal(t, []interface{}{2, 3}, arr)
+
+	// 不是通过指针传入的数组不能修改
+	err = SetToBody(map[string]interface{}{"a": [2]int{1, 2}}, "$.a[0]", 3)
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	fixed := map[string]interface{}{"a": &[2]int{1, 2}}
+	assert.Nil(t, SetToBody(fixed, "$.a[0]", 3))
+	assert.Equal(t, &[2]int{3, 2}, fixed["a"])
+}
+
+func Test_jsonpath_lossless_numbers(t *testing.T) {
+	const doc = `{"items": [
+    {"id": 9007199254740993, "price": 1.10, "n": 1e2},
+    {"id": 9007199254740992, "price": 1.1, "n": 100},
+    {"id": 18446744073709551616, "price": 0.1, "n": -0}
+]}`
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var body interface{}
+	assert.Nil(t, decoder.Decode(&body))
+
+	tests := []struct {
+		path string
+		ids  []string
+	}{
+		{"$.items[?(@.id == 9007199254740993)].id", []string{"9007199254740993"}},
+		{"$.items[?(@.id > 9007199254740992)].id", []string{"9007199254740993", "18446744073709551616"}},
+		{"$.items[?(@.id == 18446744073709551616)].id", []string{"18446744073709551616"}},
+		{"$.items[?(@.price == 1.1)].id", []string{"9007199254740993", "9007199254740992"}},
+		{"$.items[?(@.n == 100)].id", []string{"9007199254740993", "9007199254740992"}},
+		{"$.items[?(@.n == 0)].id", []string{"18446744073709551616"}},
+		{"$.items[?(@.id in [9007199254740992])].id", []string{"9007199254740992"}},
+	}
+	for _, tt := range tests {
+		matches, err := Query(body, tt.path)
+		assert.Nil(t, err, tt.path)
+		ids := make([]string, 0, len(matches))
+		for _, m := range matches {
+			ids = append(ids, m.Value.(json.Number).String())
+		}
+		assert.Equal(t, tt.ids, ids, tt.path)
+	}
+
+	// Go中的整数和big包中的数字也精确比较
+	type qty int64
+	values := map[string]interface{}{
+		"i64": int64(math.MaxInt64),
+		"u64": uint64(math.MaxUint64),
+		"big": new(big.Int).Lsh(big.NewInt(1), 70),
+		"rat": big.NewRat(1, 3),
+		"qty": qty(3),
+	}
+	for path, ok := range map[string]bool{
+		"$[?(@.i64 == 9223372036854775807)]":     true,
+		"$[?(@.i64 == 9223372036854775808)]":     false,
+		"$[?(@.u64 == 18446744073709551615)]":    true,
+		"$[?(@.u64 < 18446744073709551616)]":     true,
+		"$[?(@.big == 1180591620717411303424)]":  true,
+		"$[?(@.big > 1180591620717411303423.9)]": true,
+		"$[?(@.rat < 0.3333333333333334)]":       true,
+		"$[?(@.rat == 0.3333333333333333)]":      false,
+		"$[?(@.qty == 3)]":                       true,
+		"$[?(@.qty > 2.5)]":                      true,
+	} {
+		found, err := Exists(map[string]interface{}{"v": values}, path)
+		assert.Nil(t, err, path)
+		assert.Equal(t, ok, found, path)
+	}
+	n, err := GetInt64(values, "$.qty")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	_, err = GetInt64(values, "$.big")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+
+	// 指数太大的数字按float64比较
+	huge := map[string]interface{}{"a": []interface{}{json.Number("1e999999999")}}
+	found, err := Exists(huge, "$.a[?(@ > 1)]")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	// 修改不会改变数字的写法
+	assert.Nil(t, SetToBody(body, "$.items[0].extra", json.Number("1.50")))
+	assert.Nil(t, Rename(body, RenamesConfig{Config: []RenameConfig{{From: "$.items[*].price", To: "$.items[*].cost"}}}))
+	assert.Nil(t, DeleteBody(body, []string{"$.items[1]"}))
+	out, err := json.Marshal(body)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"items":[{"cost":1.10,"extra":1.50,"id":9007199254740993,"n":1e2},{"cost":0.1,"id":18446744073709551616,"n":-0}]}`, string(out))
+}
+
+func Test_jsonpath_lookup_bytes(t *testing.T) {
+	var body interface{}
+	assert.Nil(t, json.Unmarshal([]byte(data), &body))
+	paths := []string{
+		"$",
+		"$.store.book[*].author",
+		"$..author",
+		"$.store.*",
+		"$.store..price",
+		"$..book[2]",
+		"$..book[-1:]",
+		"$..book[?(@.isbn)].title",
+		"$..book[?(@.price < 10 && @.category == 'fiction')]",
+		"$.store.book[?(@.author =~ /.*REES/i)].title",
+		"$..book[?(@.title size 15)].title",
+		"$..*",
+		"$.missing",
+	}
+	for _, path := range paths {
+		want, wantErr := Lookup(body, path)
+		got, err := LookupBytes([]byte(data), path)
+		assert.Equal(t, wantErr, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	res, err := LookupBytes([]byte(` {"a": {"b\"c": [1, "xA", true, null, {}, []]}, "a": {"d": 1}} `), `$.a.d`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"$.a.d": float64(1)}, res)
+	res, err = LookupBytes([]byte(`{"a": {"b\"c": [1, "xA", true, null, {}, []]}}`), `$.a['b"c'][*]`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		`$.a['b"c'][0]`: float64(1), `$.a['b"c'][1]`: "xA", `$.a['b"c'][2]`: true,
+		`$.a['b"c'][3]`: nil, `$.a['b"c'][4]`: map[string]interface{}{}, `$.a['b"c'][5]`: []interface{}{},
+	}, res)
+
+	// 原有的错误和Lookup一致
+	_, err = LookupBytes([]byte(`{"a": [1]}`), "$.a[3]")
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+
+	for _, doc := range []string{``, `{"a": 1`, `{"a": [1}`, `{"a" 1}`, `{"a": 1} x`, `{"a": tru}`, `{"a": "x}`, `{"a": [1 2]}`} {
+		_, err := LookupBytes([]byte(doc), "$.a[0]")
+		assert.NotNil(t, err, doc)
+	}
+
+	// 数字和字符串和json.Unmarshal一样严格
+	for _, doc := range []string{`{"a": [+1]}`, `{"a": [.5]}`, `{"a": [01]}`, `{"a": [1.]}`, `{"a": [1e]}`, `{"a": [-]}`, `{"a": [0x1]}`,
+		"{\"a\": [\"x\x01\"]}", "{\"a\": [\"x\ty\"]}", "{\"a\": [\"x\\\\\x01\"]}"} {
+		var body interface{}
+		assert.NotNil(t, json.Unmarshal([]byte(doc), &body), doc)
+		_, err := LookupBytes([]byte(doc), "$.a[0]")
+		assert.NotNil(t, err, doc)
+	}
+	for _, doc := range []string{`{"a": [-0.5e+10]}`, `{"a": [0]}`, `{"a": [1E-2]}`, "{\"a\": [\"\xff\xfe\"]}", "{\"a\xff\": 1, \"a\": [\"\xe4\xb8\xad\"]}"} {
+		var body interface{}
+		assert.Nil(t, json.Unmarshal([]byte(doc), &body), doc)
+		want, err := Lookup(body, "$.a[0]")
+		assert.Nil(t, err, doc)
+		got, err := LookupBytes([]byte(doc), "$.a[0]")
+		assert.Nil(t, err, doc)
+		assert.Equal(t, want, got, doc)
+	}
+
+	// 错误中的位置相对整个文档
+	_, err = LookupBytes([]byte(`{"a":[1 2]}`), "$.a[*]")
+	assert.EqualError(t, err, "invalid character '2' at 8")
+	_, err = LookupBytes([]byte(`{"x": 1, "a": {"b": [true, {"c" 1}]}}`), "$.a.b[1].c")
+	assert.EqualError(t, err, "invalid character '1' at 32")
+	_, err = LookupBytes([]byte(`{"a": [1, tru]}`), "$.a[1]")
+	assert.EqualError(t, err, "invalid value tru at 10")
+}
+
+// benchDoc 是一个较大的文档, 只读取其中的一个字段
+func benchDoc() []byte {
+	items := make([]interface{}, 5000)
+	for i := range items {
+		items[i] = map[string]interface{}{
+			"id":    i,
+			"name":  fmt.Sprintf("item-%d", i),
+			"tags":  []string{"a", "b", "c"},
+			"price": float64(i) * 1.5,
+			"attrs": map[string]interface{}{"color": "red", "size": i % 10},
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"items": items, "meta": map[string]interface{}{"total": len(items)}})
+	return data
+}
+
+func BenchmarkLookupBytes(b *testing.B) {
+	doc := benchDoc()
+	c := MustCompile("$.meta.total")
+	b.Run("Unmarshal+Lookup", func(b *testing.B) {
+		b.SetBytes(int64(len(doc)))
+		for i := 0; i < b.N; i++ {
+			var body interface{}
+			if err := json.Unmarshal(doc, &body); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := c.Lookup(body); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("LookupBytes", func(b *testing.B) {
+		b.SetBytes(int64(len(doc)))
+		for i := 0; i < b.N; i++ {
+			if _, err := c.LookupBytes(doc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func Test_jsonpath_stream(t *testing.T) {
+	const doc = `{"meta": {"n": 4}, "items": [
+    {"id": 1, "x": 1, "tags": ["a"]},
+    {"id": 2, "x": 2, "tags": ["b", "c"]},
+    {"id": 3, "x": 3, "tags": []},
+    {"id": 4, "x": 0.5, "tags": ["d"]}
+], "after": [1]}`
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var body interface{}
+	assert.Nil(t, decoder.Decode(&body))
+
+	paths := []string{
+		"$.items[*].id",
+		"$.items[?(@.x > 1)]",
+		"$.items[?(@.x > 1 && @.tags size 2)].id",
+		"$.items[1:3].id",
+		"$.items[1:].tags[*]",
+		"$.items[::2].id",
+		"$.items[*]..*",
+		"$.items[0]",
+		"$.meta.n",
+		"$.meta.*",
+		"$.items[2].tags[*]",
+		"$.missing[*]",
+		"$.meta.n[*]",
+	}
+	for _, path := range paths {
+		want, err := Query(body, path)
+		assert.Nil(t, err, path)
+		var got []Match
+		err = Stream(strings.NewReader(doc), path, func(m Match) error {
+			got = append(got, m)
+			return nil
+		})
+		assert.Nil(t, err, path)
+		assert.Equal(t, len(want), len(got), path)
+		for i := range got {
+			assert.Equal(t, want[i].Path, got[i].Path, path)
+			assert.Equal(t, want[i].Value, got[i].Value, path)
+		}
+	}
+
+	// RFC 9535模式
+	var ids []interface{}
+	err := StreamWithOptions(strings.NewReader(`[{"a": 1}, {"a": 5}, {"b": 5}]`), "$[?@.a > 2]", Options{Mode: ModeRFC9535}, func(m Match) error {
+		ids = append(ids, m.Path)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"$[1]"}, ids)
+
+	// 迭代器, 切片的end之后不再读取
+	it, err := MustCompile("$[0:2]").Iterate(io.MultiReader(strings.NewReader(`[1, 2, 3, `), iotest.ErrReader(errors.New("unreachable"))))
+	assert.Nil(t, err)
+	var values []interface{}
+	for it.Next() {
+		values = append(values, it.Match().Value)
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []interface{}{json.Number("1"), json.Number("2")}, values)
+
+	// 回调返回的错误
+	stop := errors.New("stop")
+	n := 0
+	err = Stream(strings.NewReader(doc), "$.items[*]", func(Match) error {
+		n++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, n)
+
+	// 读取或者求值的错误
+	err = Stream(strings.NewReader(`[{"a": 1}, {"a": `), "$[*].a", func(Match) error { return nil })
+	assert.NotNil(t, err)
+	err = Stream(strings.NewReader(`[{"a": [1]}]`), "$[*].a[3]", func(Match) error { return nil })
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+
+	for _, path := range []string{"$..id", "$.items[-1]", "$.items[0,1]", "$.items[-2:]", "$.items[::-1]", "$.items[?(@.x > $.meta.n)]"} {
+		_, err := MustCompile(path).Iterate(strings.NewReader(doc))
+		assert.ErrorIs(t, err, ErrNotStreamable, path)
+	}
+
+	// Limits对整个流累计, 不是每个元素单独计算
+	err = StreamWithOptions(strings.NewReader(`[1, 2, 3]`), "$[*]", Options{Limits: Limits{MaxResults: 1}}, func(Match) error { return nil })
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	err = StreamWithOptions(strings.NewReader(doc), "$.items[*].tags[*]", Options{Limits: Limits{MaxResults: 3}}, func(Match) error { return nil })
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	err = StreamWithOptions(strings.NewReader(doc), "$.items[*].id", Options{Limits: Limits{MaxNodes: 6}}, func(Match) error { return nil })
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	err = StreamWithOptions(strings.NewReader(doc), "$.items[*].id", Options{Limits: Limits{MaxResults: 4, MaxNodes: 8}}, func(Match) error { return nil })
+	assert.Nil(t, err)
+
+	// 找不到要遍历的值时, 错误和Lookup一致
+	for _, opts := range []Options{{}, {Mode: ModeRFC9535}, {Policy: PolicyStrict}, {Policy: PolicyLenient}, {Policy: PolicyRFC9535}} {
+		for _, path := range []string{"$.items[5]", "$.items[5].id", "$.items[9][*]", "$.meta[0]", "$.meta.n.x", "$.missing.x", "$.meta.n[1:]", "$.meta[1:]", "$.after[0]"} {
+			_, want := LookupWithOptions(body, path, opts)
+			err := StreamWithOptions(strings.NewReader(doc), path, opts, func(Match) error { return nil })
+			assert.Equal(t, want, err, "%s %+v", path, opts)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSafeInt I-JSON能精确表示的最大整数, 索引和切片参数不能超出这个范围
+const maxSafeInt = 1<<53 - 1
+
+// query 是解析后的JsonPath, 由若干segment组成
+type query struct {
+	relative bool // 以@开头, 只出现在filter中
+	segments []segment
+}
+
+// singular 判断query是否最多只能选中一个节点(只包含单个key或者单个idx)
+func (q *query) singular() bool {
+	for _, seg := range q.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return false
+		}
+		if op := seg.selectors[0].op; op != keyType && op != idxType {
+			return false
+		}
+	}
+	return true
+}
+
+// segment 对应`.key`、`[...]`和`..`, 一个segment可以包含多个selector
+type segment struct {
+	descendant bool
+	selectors  []selector
+}
+
+// selector 的op复用keyType、idxType、rangeType、filterType和scanType
+type selector struct {
+	op     string
+	key    string
+	index  int
+	slice  sliceArgs
+	filter expr
+}
+
+type sliceArgs struct {
+	start, end, step int
+	hasStart, hasEnd bool
+}
+
+type parser struct {
+	path     string
+	pos      int
+	legacy   bool // 兼容ModeLegacy的写法, 例如$.a.@b、$[0].[1]和[?(@.a == b)]
+	inFilter bool // 正在解析ModeLegacy的filter, key遇到空白和运算符时结束
+}
+
+// parseQuery 按mode对应的语法解析JsonPath
+func parseQuery(path string, mode Mode) (*query, error) {
+	p := &parser{path: path, legacy: mode == ModeLegacy}
+	if !p.eat('$') && !(p.legacy && p.eat('@')) {
+		return nil, p.errorf("$ should in front of path")
+	}
+	q := &query{}
+	if err := p.parseSegments(q); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.path) {
+		return nil, p.errorf("unexpected character %q", p.peekRune())
+	}
+	return q, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	token := ""
+	if p.pos < len(p.path) {
+		token = string(p.peekRune())
+	}
+	return &SyntaxError{Query: p.path, Offset: p.pos, Token: token, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.path) {
+		return 0
+	}
+	return p.path[p.pos]
+}
+
+func (p *parser) peekRune() rune {
+	r, _ := utf8.DecodeRuneInString(p.path[p.pos:])
+	return r
+}
+
+func (p *parser) eat(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *parser) eatString(s string) bool {
+	if !strings.HasPrefix(p.path[p.pos:], s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+func (p *parser) skipBlank() {
+	for p.pos < len(p.path) {
+		switch p.path[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parseSegments(q *query) error {
+	for {
+		start := p.pos
+		p.skipBlank()
+		var (
+			seg segment
+			err error
+		)
+		switch {
+		case p.eatString(".."):
+			seg, err = p.parseDescendant()
+		case p.legacy && p.eatString(".["):
+			p.pos--
+			seg, err = p.parseBracketed()
+		case p.eat('.'):
+			seg, err = p.parseDotted()
+		case p.peek() == '[':
+			seg, err = p.parseBracketed()
+		default:
+			// 空白不属于query, 交给上层处理
+			p.pos = start
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seg)
+	}
+}
+
+// isKey 判断segment是否只包含单个key
+func (s segment) isKey() bool {
+	return !s.descendant && len(s.selectors) == 1 && s.selectors[0].op == keyType
+}
+
+// formatSegments 把segments还原成ModeLegacy的写法
+func formatSegments(segs []segment) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, seg := range segs {
+		b.WriteString(formatSegment(seg))
+	}
+	return b.String()
+}
+
+func formatSegment(seg segment) string {
+	if len(seg.selectors) == 1 && seg.selectors[0].op == keyType {
+		name := formatName(seg.selectors[0].key)
+		if seg.descendant && strings.HasPrefix(name, ".") {
+			return "." + name
+		}
+		if seg.descendant {
+			return ".." + name
+		}
+		return name
+	}
+	if seg.descendant && len(seg.selectors) == 1 && seg.selectors[0].op == scanType {
+		return "..*"
+	}
+	parts := make([]string, 0, len(seg.selectors))
+	for _, sel := range seg.selectors {
+		switch sel.op {
+		case keyType:
+			parts = append(parts, "'"+escapeName(sel.key)+"'")
+		case idxType:
+			parts = append(parts, strconv.Itoa(sel.index))
+		case rangeType:
+			parts = append(parts, sel.slice.String())
+		case scanType:
+			parts = append(parts, "*")
+		case filterType:
+			parts = append(parts, "?("+formatLegacyExpr(sel.filter)+")")
+		}
+	}
+	prefix := ""
+	if seg.descendant {
+		prefix = ".."
+	}
+	return prefix + "[" + strings.Join(parts, ",") + "]"
+}
+
+func (a sliceArgs) String() string {
+	var b strings.Builder
+	if a.hasStart {
+		b.WriteString(strconv.Itoa(a.start))
+	}
+	b.WriteString(":")
+	if a.hasEnd {
+		b.WriteString(strconv.Itoa(a.end))
+	}
+	if a.step != 1 {
+		b.WriteString(":" + strconv.Itoa(a.step))
+	}
+	return b.String()
+}
+
+func (p *parser) parseDotted() (segment, error) {
+	if p.eat('*') {
+		return segment{selectors: []selector{{op: scanType}}}, nil
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return segment{}, err
+	}
+	return segment{selectors: []selector{{op: keyType, key: name}}}, nil
+}
+
+func (p *parser) parseDescendant() (segment, error) {
+	var (
+		seg segment
+		err error
+	)
+	if p.legacy {
+		// 和原来的tokenize一样, 连续的多个.等价于..
+		for p.eat('.') {
+		}
+	}
+	if p.peek() == '[' {
+		seg, err = p.parseBracketed()
+	} else {
+		seg, err = p.parseDotted()
+	}
+	seg.descendant = true
+	return seg, err
+}
+
+func (p *parser) parseBracketed() (segment, error) {
+	seg := segment{}
+	p.pos++ // [
+	p.skipBlank()
+	for {
+		sel, err := p.parseSelector()
+		if err != nil {
+			return seg, err
+		}
+		seg.selectors = append(seg.selectors, sel)
+		p.skipBlank()
+		if p.eat(',') {
+			p.skipBlank()
+			continue
+		}
+		if p.eat(']') {
+			return seg, nil
+		}
+		if p.pos >= len(p.path) {
+			return seg, p.errorf("missing ]")
+		}
+		return seg, p.errorf("unexpected character %q", p.peekRune())
+	}
+}
+
+func (p *parser) parseSelector() (selector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.parseString()
+		return selector{op: keyType, key: name}, err
+	case c == '*':
+		p.pos++
+		return selector{op: scanType}, nil
+	case c == '?' && p.legacy:
+		return p.parseLegacyFilter()
+	case c == '?':
+		p.pos++
+		p.skipBlank()
+		e, err := p.parseLogical()
+		if err != nil {
+			return selector{}, err
+		}
+		return selector{op: filterType, filter: e}, nil
+	case c == ':' || c == '-' || isDigit(c):
+		return p.parseIndexOrSlice()
+	case c == 0:
+		return selector{}, p.errorf("missing selector")
+	default:
+		return selector{}, p.errorf("invalid selector %q", p.peekRune())
+	}
+}
+
+func (p *parser) parseIndexOrSlice() (selector, error) {
+	args := sliceArgs{step: 1}
+	if p.peek() != ':' {
+		n, err := p.parseInt()
+		if err != nil {
+			return selector{}, err
+		}
+		p.skipBlank()
+		if p.peek() != ':' {
+			return selector{op: idxType, index: n}, nil
+		}
+		args.start, args.hasStart = n, true
+	}
+	p.pos++ // :
+	p.skipBlank()
+	if c := p.peek(); c == '-' || isDigit(c) {
+		n, err := p.parseInt()
+		if err != nil {
+			return selector{}, err
+		}
+		args.end, args.hasEnd = n, true
+		p.skipBlank()
+	}
+	if p.eat(':') {
+		p.skipBlank()
+		if c := p.peek(); c == '-' || isDigit(c) {
+			n, err := p.parseInt()
+			if err != nil {
+				return selector{}, err
+			}
+			args.step = n
+		}
+	}
+	return selector{op: rangeType, slice: args}, nil
+}
+
+// parseInt 解析 "0" / ["-"] DIGIT1 *DIGIT
+func (p *parser) parseInt() (int, error) {
+	start := p.pos
+	negative := p.eat('-')
+	switch c := p.peek(); {
+	case c == '0' && !p.legacy:
+		p.pos++
+		if negative {
+			return 0, p.errorf("invalid integer -0")
+		}
+		if isDigit(p.peek()) {
+			return 0, p.errorf("leading zeros are not allowed")
+		}
+		return 0, nil
+	case isDigit(c):
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+	default:
+		return 0, p.errorf("invalid integer")
+	}
+	n, err := strconv.ParseInt(p.path[start:p.pos], 10, 64)
+	if err != nil || n > maxSafeInt || n < -maxSafeInt {
+		return 0, p.errorf("integer %s out of range", p.path[start:p.pos])
+	}
+	return int(n), nil
+}
+
+// parseName 解析member-name-shorthand, 原有语法中直到下一个.或者[之前都算作key
+func (p *parser) parseName() (string, error) {
+	start := p.pos
+	if p.legacy {
+		for p.pos < len(p.path) && p.path[p.pos] != '.' && p.path[p.pos] != '[' {
+			if p.inFilter && isFilterDelim(p.path[p.pos]) {
+				break
+			}
+			p.pos++
+		}
+	}
+	for p.pos < len(p.path) {
+		r, size := utf8.DecodeRuneInString(p.path[p.pos:])
+		if !isNameFirst(r) && !(p.pos > start && r < utf8.RuneSelf && isDigit(byte(r))) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		if p.pos >= len(p.path) {
+			return "", p.errorf("missing member name")
+		}
+		return "", p.errorf("invalid member name %q", p.peekRune())
+	}
+	return p.path[start:p.pos], nil
+}
+
+// parseString 解析单引号或双引号包起来的字符串, 支持json风格的转义
+func (p *parser) parseString() (string, error) {
+	quote := p.path[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.path) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.path[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			p.pos++
+			if err := p.parseEscape(&b, quote); err != nil {
+				return "", err
+			}
+		case c < 0x20:
+			return "", p.errorf("invalid character %q in string", c)
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) parseEscape(b *strings.Builder, quote byte) error {
+	c := p.peek()
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case '/', '\\', quote:
+		b.WriteByte(c)
+	case 'u':
+		p.pos++
+		r, err := p.parseHex()
+		if err != nil {
+			return err
+		}
+		switch {
+		case r >= 0xDC00 && r <= 0xDFFF:
+			return p.errorf("invalid low surrogate")
+		case r >= 0xD800 && r <= 0xDBFF:
+			if !p.eatString(`\u`) {
+				return p.errorf("missing low surrogate")
+			}
+			low, err := p.parseHex()
+			if err != nil {
+				return err
+			}
+			if low < 0xDC00 || low > 0xDFFF {
+				return p.errorf("invalid low surrogate")
+			}
+			r = (r-0xD800)<<10 + (low - 0xDC00) + 0x10000
+		}
+		b.WriteRune(r)
+		return nil
+	default:
+		return p.errorf("invalid escape %q", p.peekRune())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) parseHex() (rune, error) {
+	if p.pos+4 > len(p.path) {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	n, err := strconv.ParseUint(p.path[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	p.pos += 4
+	return rune(n), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLower(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isNameFirst(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' ||
+		r >= 0x80 && r <= 0xD7FF || r >= 0xE000 && r <= 0x10FFFF
+}
@@ -0,0 +1,354 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// LookupBytes 和Lookup一样, 但是直接查询json原文, 不需要先json.Unmarshal整个文档.
+// 只解析路径经过的object和array, 选中的值才会完整解析, 结果和json.Unmarshal之后再Lookup相同.
+// 没有经过的部分只检查括号和字符串是否完整
+func LookupBytes(data []byte, jsonPath string) (map[string]interface{}, error) {
+	c, err := compileCached(jsonPath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return c.LookupBytes(data)
+}
+
+// LookupBytes 和jsonpath.LookupBytes一样, 省去了每次解析jsonPath的开销
+func (c *Compiled) LookupBytes(data []byte) (map[string]interface{}, error) {
+	doc := &rawDoc{}
+	root, err := doc.root(data)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Lookup(root)
+	if err == nil {
+		err = doc.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range res {
+		if r, ok := v.(*rawNode); ok {
+			var value interface{}
+			if err := json.Unmarshal(r.data, &value); err != nil {
+				return nil, err
+			}
+			res[k] = value
+		}
+	}
+	return res, nil
+}
+
+// rawDoc 是查询的json原文, 记录解析时遇到的第一个错误
+type rawDoc struct {
+	data []byte
+	err  error
+}
+
+// rawNode 是json原文中还没有解析的object或array, 第一次访问时只解析一层, 子节点在访问时才转换
+type rawNode struct {
+	doc    *rawDoc
+	data   []byte
+	offset int // data在doc.data中的位置, 错误中的位置都相对整个文档
+	object bool
+	parsed bool
+	raws   []rawSpan      // 子节点的原文
+	index  map[string]int // object中key对应的子节点, 重复的key和json.Unmarshal一样取最后一个
+	sorted []string       // 去重并排序的key, 用于children
+}
+
+// rawSpan 是一个json值在doc.data中的范围
+type rawSpan struct {
+	start, end int
+}
+
+func (d *rawDoc) root(data []byte) (interface{}, error) {
+	d.data = data
+	start := skipSpace(data, 0)
+	end, err := skipValue(data, start)
+	if err != nil {
+		return nil, err
+	}
+	if i := skipSpace(data, end); i != len(data) {
+		return nil, rawError(data, i)
+	}
+	v := d.value(rawSpan{start: start, end: end})
+	return v, d.err
+}
+
+// value 把一个json值的原文转换成查询使用的值: object和array是*rawNode, 其他值和json.Unmarshal的结果一样
+func (d *rawDoc) value(span rawSpan) interface{} {
+	raw := d.data[span.start:span.end]
+	switch raw[0] {
+	case '{', '[':
+		return &rawNode{doc: d, data: raw, offset: span.start, object: raw[0] == '{'}
+	case '"':
+		s, ok := unquote(raw)
+		if !ok {
+			d.fail(fmt.Errorf("invalid string %s at %d", raw, span.start))
+		}
+		return s
+	case 't', 'f', 'n':
+		switch string(raw) {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+	default:
+		if isNumberLiteral(raw) {
+			if f, err := strconv.ParseFloat(string(raw), 64); err == nil {
+				return f
+			}
+		}
+	}
+	d.fail(fmt.Errorf("invalid value %s at %d", raw, span.start))
+	return nil
+}
+
+func (d *rawDoc) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// parse 解析一层object或array, 子节点只记录原文. 在整个文档上解析, 这样错误中的位置是相对文档的
+func (r *rawNode) parse() {
+	if r.parsed {
+		return
+	}
+	r.parsed = true
+	if r.object {
+		r.index = make(map[string]int)
+	}
+	data := r.doc.data
+	last := r.offset + len(r.data) - 1 // 结尾的}或]
+	i := skipSpace(data, r.offset+1)
+	if i == last {
+		return
+	}
+	for i < last {
+		if r.object {
+			if data[i] != '"' {
+				break
+			}
+			end, err := skipString(data, i)
+			if err != nil {
+				r.doc.fail(err)
+				return
+			}
+			key, ok := unquote(data[i:end])
+			if !ok {
+				break
+			}
+			if i = skipSpace(data, end); i >= last || data[i] != ':' {
+				break
+			}
+			r.index[key] = len(r.raws)
+			i = skipSpace(data, i+1)
+		}
+		end, err := skipValue(data, i)
+		if err != nil {
+			r.doc.fail(err)
+			return
+		}
+		r.raws = append(r.raws, rawSpan{start: i, end: end})
+		i = skipSpace(data, end)
+		if i < last && data[i] == ',' {
+			i = skipSpace(data, i+1)
+			continue
+		}
+		if i == last {
+			return
+		}
+		break
+	}
+	r.doc.fail(rawError(data, i))
+}
+
+func (r *rawNode) member(key string) (interface{}, bool) {
+	if !r.object {
+		return nil, false
+	}
+	r.parse()
+	i, ok := r.index[key]
+	if !ok {
+		return nil, false
+	}
+	return r.doc.value(r.raws[i]), true
+}
+
+func (r *rawNode) len() int {
+	r.parse()
+	if r.object {
+		return len(r.index)
+	}
+	return len(r.raws)
+}
+
+func (r *rawNode) element(i int) interface{} {
+	r.parse()
+	return r.doc.value(r.raws[i])
+}
+
+// children 按key的字典序遍历object的成员
+func (r *rawNode) children(n node, emit func(node) bool) bool {
+	r.parse()
+	if r.sorted == nil {
+		r.sorted = make([]string, 0, len(r.index))
+		for k := range r.index {
+			r.sorted = append(r.sorted, k)
+		}
+		sort.Strings(r.sorted)
+	}
+	for _, k := range r.sorted {
+		if !emit(node{value: r.doc.value(r.raws[r.index[k]]), parent: n.value, loc: n.loc.member(k)}) {
+			return false
+		}
+	}
+	return true
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipValue 返回从i开始的json值结束的位置, 只检查括号和字符串是否完整
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, rawError(data, i)
+	}
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		var stack []byte
+		for ; i < len(data); i++ {
+			switch c := data[i]; c {
+			case '"':
+				end, err := skipString(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end - 1
+			case '{', '[':
+				stack = append(stack, c+2) // '{'+2 == '}', '['+2 == ']'
+			case '}', ']':
+				if stack[len(stack)-1] != c {
+					return 0, rawError(data, i)
+				}
+				if stack = stack[:len(stack)-1]; len(stack) == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return 0, rawError(data, i)
+	}
+	end := i
+	for end < len(data) && !isRawDelim(data[end]) {
+		end++
+	}
+	if end == i {
+		return 0, rawError(data, i)
+	}
+	return end, nil
+}
+
+func isRawDelim(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', ',', ':', ']', '}':
+		return true
+	}
+	return false
+}
+
+func skipString(data []byte, i int) (int, error) {
+	for j := i + 1; j < len(data); j++ {
+		switch data[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		}
+	}
+	return 0, rawError(data, len(data))
+}
+
+// unquote 解析json字符串, 没有转义字符、控制字符并且是合法的UTF-8时直接截取,
+// 其他情况交给json.Unmarshal: 控制字符返回错误, 不合法的UTF-8替换成U+FFFD
+func unquote(raw []byte) (string, bool) {
+	s := raw[1 : len(raw)-1]
+	fast := utf8.Valid(s)
+	for _, c := range s {
+		if c == '\\' || c < 0x20 {
+			fast = false
+			break
+		}
+	}
+	if fast {
+		return string(s), true
+	}
+	var res string
+	err := json.Unmarshal(raw, &res)
+	return res, err == nil
+}
+
+// isNumberLiteral 按RFC 8259的语法检查数字: -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+func isNumberLiteral(raw []byte) bool {
+	i := 0
+	if i < len(raw) && raw[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(raw) && raw[i] == '0':
+		i++
+	case i < len(raw) && raw[i] >= '1' && raw[i] <= '9':
+		i = skipDigits(raw, i)
+	default:
+		return false
+	}
+	if i < len(raw) && raw[i] == '.' {
+		if j := skipDigits(raw, i+1); j > i+1 {
+			i = j
+		} else {
+			return false
+		}
+	}
+	if i < len(raw) && (raw[i] == 'e' || raw[i] == 'E') {
+		i++
+		if i < len(raw) && (raw[i] == '+' || raw[i] == '-') {
+			i++
+		}
+		if j := skipDigits(raw, i); j > i {
+			i = j
+		} else {
+			return false
+		}
+	}
+	return i == len(raw)
+}
+
+func skipDigits(raw []byte, i int) int {
+	for i < len(raw) && raw[i] >= '0' && raw[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+func rawError(data []byte, i int) error {
+	if i >= len(data) {
+		return fmt.Errorf("unexpected end of JSON input")
+	}
+	return fmt.Errorf("invalid character %q at %d", data[i], i)
+}
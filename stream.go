@@ -0,0 +1,418 @@
+package jsonpath
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Stream 从r中按流的方式读取json, 每选中一个值就调用fn, fn返回错误时停止读取并返回这个错误.
+// 适合很大的json, 例如只有一个数组的导出文件: 路径前面只能是key和非负的下标, 然后可以有一个[*]、切片或者filter,
+// 之后的部分在每个元素中求值, 所以同时只会在内存中保存一个元素. 具体的限制见Compiled.Iterate
+func Stream(r io.Reader, jsonPath string, fn func(Match) error) error {
+	return StreamWithOptions(r, jsonPath, Options{}, fn)
+}
+
+// StreamWithOptions 和Stream一样, 但是可以通过opts选择方言
+func StreamWithOptions(r io.Reader, jsonPath string, opts Options, fn func(Match) error) error {
+	c, err := compileCached(jsonPath, opts)
+	if err != nil {
+		return err
+	}
+	return c.Stream(r, fn)
+}
+
+// Stream 和jsonpath.Stream一样, 省去了每次解析jsonPath的开销
+func (c *Compiled) Stream(r io.Reader, fn func(Match) error) error {
+	it, err := c.Iterate(r)
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		if err := fn(it.Match()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Iterate 返回按流的方式读取r的Iterator. 路径需要满足:
+//   - 开头是任意个.key或者非负的[n], 用来找到要遍历的数组或者object, 例如$.data.items
+//   - 然后最多一个[*]、.*、[?(...)]或者非负的切片, 用来遍历其中的元素
+//   - 之后的部分可以是任意的路径, 在每个元素中求值
+//   - filter中不能使用$开头的子路径
+//
+// 不满足时返回ErrNotStreamable. 数字解析成json.Number, 元素按在r中出现的顺序返回, object的key不会排序;
+// 找不到要遍历的数组时和Lookup一样, 按Options的Mode和Policy返回*IndexError、*TypeError、*NotFoundError或者没有结果.
+// 遍历的元素本身的Match.Parent为nil
+func (c *Compiled) Iterate(r io.Reader) (*Iterator, error) {
+	plan, err := c.streamPlan()
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Iterator{c: c, plan: plan, dec: dec, e: c.newEvaluator(context.Background(), nil)}, nil
+}
+
+// Iterator 按流的方式依次返回选中的值, 用法和bufio.Scanner一样:
+//
+//	for it.Next() {
+//		m := it.Match()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator struct {
+	c    *Compiled
+	plan streamPlan
+	dec  *json.Decoder
+	e    *evaluator // 所有元素共用, Limits中选中的值和访问的节点数对整个流累计
+
+	started bool
+	done    bool
+	object  bool      // 正在遍历的是object
+	base    *location // 正在遍历的数组或者object的路径
+	index   int       // 下一个元素的下标
+	pending []Match   // 当前元素中选中的值
+	current Match
+	err     error
+}
+
+// streamPlan 是把路径拆开后的三部分
+type streamPlan struct {
+	prefix []selector // 只包含单个key或者非负下标的segment
+	each   *selector  // 遍历元素的selector, 为nil时直接选中prefix指向的值
+	rest   []segment  // 在每个元素中求值的segments
+}
+
+func (c *Compiled) streamPlan() (streamPlan, error) {
+	var plan streamPlan
+	if c.query.relative {
+		return plan, fmt.Errorf("%w: %s is relative", ErrNotStreamable, c.path)
+	}
+	segs := c.query.segments
+	for len(segs) > 0 && !segs[0].descendant && len(segs[0].selectors) == 1 {
+		s := segs[0].selectors[0]
+		if s.op != keyType && (s.op != idxType || s.index < 0) {
+			break
+		}
+		plan.prefix = append(plan.prefix, s)
+		segs = segs[1:]
+	}
+	if len(segs) > 0 {
+		seg := segs[0]
+		if seg.descendant || len(seg.selectors) != 1 {
+			return plan, fmt.Errorf("%w: %s should select the elements with a single [*], slice or filter", ErrNotStreamable, c.path)
+		}
+		s := &seg.selectors[0]
+		switch s.op {
+		case scanType, filterType:
+		case rangeType:
+			if s.slice.step <= 0 || s.slice.start < 0 || (s.slice.hasEnd && s.slice.end < 0) {
+				return plan, fmt.Errorf("%w: slice in %s should have non-negative start, end and step", ErrNotStreamable, c.path)
+			}
+		default:
+			return plan, fmt.Errorf("%w: negative index in %s", ErrNotStreamable, c.path)
+		}
+		plan.each, plan.rest = s, segs[1:]
+	}
+	if usesRoot(c.query.segments) {
+		return plan, fmt.Errorf("%w: filter in %s uses $", ErrNotStreamable, c.path)
+	}
+	return plan, nil
+}
+
+// usesRoot 判断filter中是否有$开头的子路径
+func usesRoot(segs []segment) bool {
+	for _, seg := range segs {
+		for _, s := range seg.selectors {
+			if s.op == filterType && exprUsesRoot(s.filter) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func exprUsesRoot(x expr) bool {
+	switch x := x.(type) {
+	case orExpr:
+		return exprsUseRoot(x.operands)
+	case andExpr:
+		return exprsUseRoot(x.operands)
+	case notExpr:
+		return exprUsesRoot(x.operand)
+	case cmpExpr:
+		return exprUsesRoot(x.left) || exprUsesRoot(x.right)
+	case legacyFilter:
+		return exprUsesRoot(x.left) || exprUsesRoot(x.right)
+	case funcExpr:
+		return exprsUseRoot(x.args)
+	case queryExpr:
+		return !x.q.relative || usesRoot(x.q.segments)
+	case legacyValue:
+		return !x.q.relative || usesRoot(x.q.segments)
+	}
+	return false
+}
+
+func exprsUseRoot(xs []expr) bool {
+	for _, x := range xs {
+		if exprUsesRoot(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next 读取下一个选中的值, 没有更多的值或者出错时返回false
+func (it *Iterator) Next() bool {
+	for len(it.pending) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		if err := it.advance(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.current, it.pending = it.pending[0], it.pending[1:]
+	return true
+}
+
+// Match 返回Next读取的值
+func (it *Iterator) Match() Match {
+	return it.current
+}
+
+// Err 返回读取或者求值时的错误, 正常结束时为nil
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// advance 读取下一个元素, 把其中选中的值放到pending中
+func (it *Iterator) advance() error {
+	if !it.started {
+		it.started = true
+		found, err := it.seek()
+		if err != nil || !found {
+			it.done = true
+			return err
+		}
+		if it.plan.each == nil {
+			it.done = true
+			var v interface{}
+			if err := it.dec.Decode(&v); err != nil {
+				return err
+			}
+			return it.eval(node{value: v, loc: it.base}, nil)
+		}
+		t, err := it.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t == json.Delim('['):
+		case t == json.Delim('{') && it.eachObject():
+			it.object = true
+		default:
+			it.done = true
+			// 和Lookup一样, 切片作用在不是数组的值上时按Policy决定是否返回错误
+			if it.plan.each.op == rangeType {
+				if e := it.e; e.strict && (t != nil || e.missing) {
+					return &TypeError{Path: it.base.normalized(), Expected: "array", Actual: tokenType(t)}
+				}
+			}
+			return skipRest(it.dec, t)
+		}
+	}
+	if !it.dec.More() {
+		it.done = true
+		_, err := it.dec.Token()
+		return err
+	}
+	var loc *location
+	if it.object {
+		t, err := it.dec.Token()
+		if err != nil {
+			return err
+		}
+		loc = it.base.member(t.(string))
+	} else {
+		loc = it.base.element(it.index)
+		it.index++
+	}
+	each := it.plan.each
+	if each.op == rangeType && !it.object {
+		i, args := loc.index, it.slice()
+		if args.hasEnd && i >= args.end {
+			// 后面的元素都不会被选中
+			it.done = true
+			return nil
+		}
+		if i < args.start || (i-args.start)%args.step != 0 {
+			return skipStreamValue(it.dec)
+		}
+	}
+	var v interface{}
+	if err := it.dec.Decode(&v); err != nil {
+		return err
+	}
+	n := node{value: v, loc: loc}
+	if each.op == filterType {
+		e := it.e
+		e.root, e.current = v, loc
+		ok := e.test(each.filter, v)
+		if e.err != nil {
+			return e.err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return it.eval(n, it.plan.rest)
+}
+
+// eachObject 判断遍历元素的selector能否作用在object上. ModeLegacy中[:]作用在object上等价于[*]
+func (it *Iterator) eachObject() bool {
+	s := it.plan.each
+	if s.op == rangeType {
+		return it.c.opts.Mode == ModeLegacy && !s.slice.hasStart && !s.slice.hasEnd
+	}
+	return true
+}
+
+func (it *Iterator) slice() sliceArgs {
+	if it.c.opts.Mode == ModeLegacy && it.c.opts.InclusiveSliceEnd {
+		return it.plan.each.slice.inclusive()
+	}
+	return it.plan.each.slice
+}
+
+// eval 在一个元素中对剩下的segments求值
+func (it *Iterator) eval(n node, rest []segment) error {
+	e := it.e
+	e.root, e.current = n.value, nil
+	e.segments(n, rest, func(n node) bool {
+		e.results++
+		if max := e.limits.MaxResults; max > 0 && e.results > max {
+			e.err = &LimitError{Limit: "results", Max: max}
+			return false
+		}
+		it.pending = append(it.pending, newMatch(n, n.loc.normalized()))
+		return true
+	})
+	return e.err
+}
+
+// seek 沿着prefix找到要遍历的值, 下一个读取的token就是这个值的开始.
+// 选不中时和Lookup一样按Options的Mode和Policy决定是否返回错误
+func (it *Iterator) seek() (bool, error) {
+	e := it.e
+	for _, s := range it.plan.prefix {
+		t, err := it.dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if s.op == keyType {
+			if t != json.Delim('{') {
+				if e.strict && e.missing {
+					return false, &TypeError{Path: it.base.normalized(), Expected: "object", Actual: tokenType(t)}
+				}
+				return false, nil
+			}
+			found, err := it.seekMember(s.key)
+			if err != nil {
+				return false, err
+			}
+			if !found {
+				if e.strict && e.missing {
+					return false, &NotFoundError{Path: it.base.member(s.key).normalized()}
+				}
+				return false, nil
+			}
+			it.base = it.base.member(s.key)
+			continue
+		}
+		if t != json.Delim('[') {
+			if e.strict && (t != nil || e.missing) {
+				return false, &TypeError{Path: it.base.normalized(), Expected: "array", Actual: tokenType(t)}
+			}
+			return false, nil
+		}
+		for i := 0; ; i++ {
+			if !it.dec.More() {
+				if e.strict {
+					return false, &IndexError{Path: it.base.normalized(), Index: s.index, Length: i}
+				}
+				return false, nil
+			}
+			if i == s.index {
+				break
+			}
+			if err := skipStreamValue(it.dec); err != nil {
+				return false, err
+			}
+		}
+		it.base = it.base.element(s.index)
+	}
+	return true, nil
+}
+
+// tokenType 返回值的第一个token对应的类型名, 和typeName一致
+func tokenType(t json.Token) string {
+	switch t {
+	case json.Delim('{'):
+		return "object"
+	case json.Delim('['):
+		return "array"
+	}
+	return typeName(t)
+}
+
+// seekMember 在object中找到key, 跳过前面的成员. 有重复的key时使用第一个
+func (it *Iterator) seekMember(key string) (bool, error) {
+	for it.dec.More() {
+		t, err := it.dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if t == key {
+			return true, nil
+		}
+		if err := skipStreamValue(it.dec); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// skipStreamValue 跳过下一个值. 只读取token, 不会把整个值保存在内存中
+func skipStreamValue(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	return skipRest(dec, t)
+}
+
+// skipRest 跳过已经读取了第一个token的值
+func skipRest(dec *json.Decoder, t json.Token) error {
+	depth := 0
+	for {
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+		var err error
+		if t, err = dec.Token(); err != nil {
+			return err
+		}
+	}
+}
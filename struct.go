@@ -0,0 +1,224 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indirect 取出指针指向的值, nil指针等价于null. big包中的数字是指针, 作为数字原样返回
+func indirect(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}, string, float64, bool, json.Number, *big.Int, *big.Float, *big.Rat, *rawNode:
+		return v
+	}
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr {
+		return v
+	}
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	return value.Interface()
+}
+
+// scalar 在indirect的基础上把自定义的string、bool、数字类型转换成基本类型, 例如type Status string,
+// filter中只比较基本类型
+func scalar(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}, string, float64, bool, json.Number, nothingType:
+		return v
+	}
+	v = indirect(v)
+	if v == nil {
+		return nil
+	}
+	value := reflect.ValueOf(v)
+	if value.Type().PkgPath() == "" {
+		return v
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint()
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	return v
+}
+
+// field 是struct中按encoding/json的规则序列化的一个字段
+type field struct {
+	name      string
+	index     []int // 用于reflect.Value.FieldByIndex, 包括嵌入的struct
+	omitEmpty bool
+	tagged    bool // 名字来自json tag
+}
+
+// fieldCache 缓存每个struct类型的字段, 按名字排序
+var fieldCache sync.Map // map[reflect.Type][]field
+
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+// typeFields 按encoding/json的规则列出struct的字段: 忽略json:"-"和未导出的字段, 没有tag名字的嵌入struct展开它的字段.
+// 同名的字段层数浅的优先, 同一层中只有一个带tag名字的字段时用它, 否则都忽略
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	var res []field
+	seen := make(map[string]bool)
+	visited := make(map[reflect.Type]bool)
+	for next := []embedded{{typ: t}}; len(next) > 0; {
+		current := next
+		next = nil
+		byName := make(map[string][]field)
+		for _, em := range current {
+			if visited[em.typ] {
+				continue
+			}
+			visited[em.typ] = true
+			for i := 0; i < em.typ.NumField(); i++ {
+				sf := em.typ.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				// 未导出的嵌入struct仍然展开它导出的字段
+				if sf.PkgPath != "" && !(sf.Anonymous && ft.Kind() == reflect.Struct) {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), em.index...), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+				f := field{name: name, index: index, tagged: name != ""}
+				if name == "" {
+					f.name = sf.Name
+				}
+				for _, opt := range strings.Split(opts, ",") {
+					if opt == "omitempty" {
+						f.omitEmpty = true
+					}
+				}
+				byName[f.name] = append(byName[f.name], f)
+			}
+		}
+		for name, fields := range byName {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if f, ok := dominantField(fields); ok {
+				res = append(res, f)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// dominantField 同一层中有多个同名字段时, 只有一个带tag名字的字段可以保留
+func dominantField(fields []field) (field, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var res []field
+	for _, f := range fields {
+		if f.tagged {
+			res = append(res, f)
+		}
+	}
+	if len(res) == 1 {
+		return res[0], true
+	}
+	return field{}, false
+}
+
+// fieldValue 返回字段的值, 嵌入的指针为nil或者omitempty的字段为空值时ok为false
+func fieldValue(v reflect.Value, f field) (reflect.Value, bool) {
+	for i, x := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	if f.omitEmpty && isEmptyValue(v) {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+// isEmptyValue 和encoding/json中omitempty的判断一致
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// structMember 按json中的名字取struct的字段
+func structMember(v reflect.Value, key string) (interface{}, bool) {
+	fields := cachedFields(v.Type())
+	i := sort.Search(len(fields), func(i int) bool {
+		return fields[i].name >= key
+	})
+	if i == len(fields) || fields[i].name != key {
+		return nil, false
+	}
+	fv, ok := fieldValue(v, fields[i])
+	if !ok {
+		return nil, false
+	}
+	return fv.Interface(), true
+}
+
+// structFields 按名字的顺序遍历struct序列化后的字段, emit返回false时停止
+func structFields(v reflect.Value, emit func(name string, value interface{}) bool) bool {
+	for _, f := range cachedFields(v.Type()) {
+		if fv, ok := fieldValue(v, f); ok && !emit(f.name, fv.Interface()) {
+			return false
+		}
+	}
+	return true
+}